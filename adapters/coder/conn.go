@@ -6,33 +6,125 @@ package coder
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	wrapper "github.com/bminer/ws-server-wrapper-go"
 	"github.com/coder/websocket"
-	"github.com/coder/websocket/wsjson"
 )
 
 // Wrap wraps a *websocket.Conn from github.com/coder/websocket as a
 // wrapper.Conn that can be passed to wrapper.Server.Accept or
-// wrapper.Client.Bind.
+// wrapper.Client.Bind. Messages are encoded with the codec registered for the
+// negotiated subprotocol (see wrapper.CodecFor); if no subprotocol was
+// negotiated, JSON is used.
 func Wrap(c *websocket.Conn) wrapper.Conn {
-	return conn{c}
+	return WrapCodec(c, wrapper.CodecFor(c.Subprotocol()))
 }
 
-// conn implements the wrapper.Conn interface for a websocket.Conn
+// WrapCodec is like Wrap, but messages are encoded with the given codec.
+func WrapCodec(c *websocket.Conn, codec wrapper.Codec) wrapper.Conn {
+	return conn{c, codec, make(chan struct{}, 1)}
+}
+
+// conn implements the wrapper.CodecConn interface for a websocket.Conn
 type conn struct {
 	*websocket.Conn
+	codec    wrapper.Codec
+	writeSem chan struct{} // held while writing a message and its attachments
 }
 
-// ReadMessage reads a single message from the connection
+// Codec returns the codec used to encode messages
+func (c conn) Codec() wrapper.Codec {
+	return c.codec
+}
+
+// ReadMessage reads a single message from the connection, followed by its
+// binary attachments (see wrapper.Message.Attachments). If the remote end
+// closed the connection, a wrapper.CloseError with its status code and reason
+// is returned.
 func (c conn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
-	// Note: message type is ignored by wsjson.Read
-	return wsjson.Read(ctx, c.Conn, msg)
+	// Note: message type is ignored
+	_, data, err := c.Conn.Read(ctx)
+	if err != nil {
+		return readError(err)
+	}
+	if err := c.codec.UnmarshalMessage(data, msg); err != nil {
+		return err
+	}
+	for i := range msg.Attachments {
+		typ, data, err := c.Conn.Read(ctx)
+		if err != nil {
+			return readError(err)
+		}
+		if typ != websocket.MessageBinary {
+			return errors.New("expected binary attachment")
+		}
+		msg.Attachments[i] = data
+	}
+	return nil
+}
+
+// readError converts a websocket.CloseError received from the remote end into
+// a wrapper.CloseError and wraps read limit errors with
+// wrapper.ErrMessageTooBig. Other errors are returned unchanged.
+func readError(err error) error {
+	var ce websocket.CloseError
+	if errors.As(err, &ce) {
+		return wrapper.CloseError{
+			Code: wrapper.StatusCode(ce.Code), Reason: ce.Reason,
+		}
+	} else if errors.Is(err, websocket.ErrMessageTooBig) {
+		return fmt.Errorf("%w: %w", wrapper.ErrMessageTooBig, err)
+	}
+	return err
 }
 
-// WriteMessage writes a message to the connection
+// WriteMessage writes a message to the connection as a text frame, or as a
+// binary frame if the codec is binary. Its attachments are written as binary
+// frames immediately after the message.
 func (c conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
-	return wsjson.Write(ctx, c.Conn, msg)
+	data, err := msg.Encode(c.codec)
+	if err != nil {
+		return err
+	}
+	typ := websocket.MessageText
+	if c.codec.Binary() {
+		typ = websocket.MessageBinary
+	}
+	// Prevent other messages from being written between the message and its
+	// attachments
+	select {
+	case c.writeSem <- struct{}{}:
+		defer func() { <-c.writeSem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := c.Conn.Write(ctx, typ, data); err != nil {
+		return err
+	}
+	for _, attachment := range msg.Attachments {
+		err := c.Conn.Write(ctx, websocket.MessageBinary, attachment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetReadLimit sets the maximum size in bytes of a WebSocket message read from
+// the connection (see wrapper.Limits). If a larger message is received, the
+// connection is closed with StatusMessageTooBig and ReadMessage returns an
+// error wrapping wrapper.ErrMessageTooBig. The default limit of
+// github.com/coder/websocket is 32 KiB.
+func (c conn) SetReadLimit(n int64) {
+	c.Conn.SetReadLimit(n)
+}
+
+// Ping sends a WebSocket ping and waits for the pong or for ctx to be done.
+// It is used by the heartbeat (see wrapper.Server.SetHeartbeat).
+func (c conn) Ping(ctx context.Context) error {
+	return c.Conn.Ping(ctx)
 }
 
 // Close performs the WebSocket close handshake with the given status code and reason
@@ -1,10 +1,16 @@
 package coder
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
 
 	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/bminer/ws-server-wrapper-go/codecs/msgpack"
 	"github.com/coder/websocket"
 )
 
@@ -42,3 +48,186 @@ func Example() {
 	// Start the HTTP server
 	log.Fatal(http.ListenAndServe("localhost:8080", h))
 }
+
+func ExampleHandler() {
+	wsServer := wrapper.NewServer()
+	wsServer.On("whoami", func(ctx context.Context) (any, error) {
+		return wrapper.ClientFromContext(ctx).Get("user"), nil
+	})
+
+	// Reject requests without a user and attach the user to the client
+	h := Handler(wsServer, HandlerOptions{
+		BeforeUpgrade: func(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+			user := r.Header.Get("X-User")
+			if user == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return nil, false
+			}
+			return map[string]any{"user": user}, true
+		},
+	})
+	log.Fatal(http.ListenAndServe("localhost:8080", h))
+}
+
+// dial connects a wrapper.Client to the WebSocket server at url. Handlers can
+// be registered with register before the connection is bound.
+func dial(
+	t *testing.T, url string, opts *websocket.DialOptions,
+	register func(*wrapper.Client),
+) *wrapper.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(url, "http"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := wrapper.NewClient(nil)
+	if register != nil {
+		register(client)
+	}
+	client.Bind(Wrap(c))
+	return client
+}
+
+// TestServerShutdown verifies that Server.Shutdown keeps the connection open
+// while an in-flight handler sends a request to the client and responds, and
+// then closes the connection with the shutdown status.
+func TestServerShutdown(t *testing.T) {
+	server := wrapper.NewServer()
+	server.SetShutdownStatus(wrapper.StatusServiceRestart, "restarting")
+	// The heartbeat must keep working while the server is shutting down
+	server.SetHeartbeat(10*time.Millisecond, 50*time.Millisecond)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.On("work", func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return wrapper.ClientFromContext(ctx).Request(ctx, "confirm")
+	})
+	ts := httptest.NewServer(Handler(server, HandlerOptions{}))
+	defer ts.Close()
+
+	closed := make(chan wrapper.StatusCode, 1)
+	client := dial(t, ts.URL, nil, func(c *wrapper.Client) {
+		c.On("confirm", func() (string, error) {
+			return "confirmed", nil
+		})
+		c.On("close", func(c *wrapper.Client, status wrapper.StatusCode, reason string, userClosed bool) {
+			closed <- status
+		})
+	})
+	defer client.Close(wrapper.StatusNormalClosure, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := make(chan any, 1)
+	go func() {
+		res, err := client.Request(ctx, "work")
+		if err != nil {
+			res = err
+		}
+		result <- res
+	}()
+	<-started
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	// Wait for several heartbeats before letting the handler finish
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.Request(ctx, "work"); err == nil ||
+		!strings.Contains(err.Error(), "shutting down") {
+		t.Fatalf("expected request to be rejected during shutdown, got %v", err)
+	}
+	close(release)
+	if res := <-result; res != "confirmed" {
+		t.Fatalf("expected handler response, got %v", res)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	select {
+	case status := <-closed:
+		if status != wrapper.StatusServiceRestart {
+			t.Fatalf("expected StatusServiceRestart, got %v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client was not closed")
+	}
+}
+
+// TestCodec verifies that the codec is selected by the negotiated subprotocol
+// and that responses are decoded directly into the type requested by
+// wrapper.Call.
+func TestCodec(t *testing.T) {
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.On("ratio", func() (float32, error) {
+		return 1.5, nil
+	})
+	server.On("data", func() ([]byte, error) {
+		return []byte{0, 1, 2}, nil
+	})
+	ts := httptest.NewServer(Handler(server, HandlerOptions{
+		Subprotocols: wrapper.Codecs(),
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, codec := range []wrapper.Codec{wrapper.JSON, msgpack.Codec} {
+		client := dial(t, ts.URL, &websocket.DialOptions{
+			Subprotocols: []string{codec.Name()},
+		}, nil)
+		if client.Codec() != codec {
+			t.Fatalf("expected %s codec, got %s", codec.Name(), client.Codec().Name())
+		}
+		ratio, err := wrapper.Call[float32](ctx, client, "ratio")
+		if err != nil || ratio != 1.5 {
+			t.Fatalf("%s: expected 1.5, got %v (%v)", codec.Name(), ratio, err)
+		}
+		data, err := wrapper.Call[[]byte](ctx, client, "data")
+		if err != nil || string(data) != "\x00\x01\x02" {
+			t.Fatalf("%s: expected data, got %v (%v)", codec.Name(), data, err)
+		}
+		client.Close(wrapper.StatusNormalClosure, "")
+	}
+}
+
+// TestReadLimit verifies that a message exceeding Limits.MaxMessageSize is
+// rejected by the read limit and closes the connection with
+// StatusMessageTooBig on both ends.
+func TestReadLimit(t *testing.T) {
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.SetLimits(wrapper.Limits{MaxMessageSize: 64})
+	closed := make(chan wrapper.StatusCode, 2)
+	onClose := func(_ *wrapper.Client, status wrapper.StatusCode, _ string, _ bool) {
+		closed <- status
+	}
+	server.On("close", onClose)
+	ts := httptest.NewServer(Handler(server, HandlerOptions{}))
+	defer ts.Close()
+
+	client := dial(t, ts.URL, nil, func(c *wrapper.Client) {
+		c.On("close", onClose)
+	})
+	defer client.Close(wrapper.StatusNormalClosure, "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Emit(ctx, "upload", strings.Repeat("a", 100)); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case status := <-closed:
+			if status != wrapper.StatusMessageTooBig {
+				t.Fatalf("expected status %d, got %d", wrapper.StatusMessageTooBig, status)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("connection was not closed")
+		}
+	}
+}
@@ -4,7 +4,15 @@ go 1.23.4
 
 require (
 	github.com/bminer/ws-server-wrapper-go v0.0.0
+	github.com/bminer/ws-server-wrapper-go/codecs/msgpack v0.0.0
 	github.com/coder/websocket v1.8.14
 )
 
+require (
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
+
 replace github.com/bminer/ws-server-wrapper-go => ../..
+
+replace github.com/bminer/ws-server-wrapper-go/codecs/msgpack => ../../codecs/msgpack
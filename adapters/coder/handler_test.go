@@ -0,0 +1,76 @@
+package coder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/coder/websocket"
+)
+
+// TestHandler verifies that Handler rejects requests from other origins and
+// requests rejected by BeforeUpgrade, and that the data returned by
+// BeforeUpgrade is set on the client.
+func TestHandler(t *testing.T) {
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.On("whoami", func(ctx context.Context) (any, error) {
+		return wrapper.ClientFromContext(ctx).Get("user"), nil
+	})
+	ts := httptest.NewServer(Handler(server, HandlerOptions{
+		OriginPatterns: []string{"*.example.com"},
+		BeforeUpgrade: func(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+			user := r.Header.Get("X-User")
+			if user == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return nil, false
+			}
+			return map[string]any{"user": user}, true
+		},
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	tests := []struct {
+		origin string
+		user   string
+		status int
+	}{
+		{"https://app.example.com", "alice", http.StatusSwitchingProtocols},
+		{"", "bob", http.StatusSwitchingProtocols},
+		{"https://evil.test", "alice", http.StatusForbidden},
+		{"https://app.example.com", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		if test.user != "" {
+			header.Set("X-User", test.user)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		c, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			HTTPHeader: header,
+		})
+		if resp == nil || resp.StatusCode != test.status {
+			cancel()
+			t.Fatalf("%+v: expected status %d, got %v (%v)", test, test.status, resp, err)
+		}
+		if err != nil {
+			cancel()
+			continue
+		}
+		client := wrapper.NewClient(Wrap(c))
+		user, err := client.Request(ctx, "whoami")
+		cancel()
+		client.Close(wrapper.StatusNormalClosure, "")
+		if err != nil || user != test.user {
+			t.Fatalf("expected user %q, got %v (%v)", test.user, user, err)
+		}
+	}
+}
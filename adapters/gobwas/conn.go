@@ -0,0 +1,357 @@
+// This package enables the use of the github.com/gobwas/ws package with the
+// ws-server-wrapper library. It provides an adapter between a net.Conn
+// upgraded or dialed by github.com/gobwas/ws and a Conn in the
+// ws-server-wrapper library.
+//
+// Note: github.com/gobwas/ws reads and writes WebSocket frames directly on the
+// net.Conn. This adapter serializes all outbound writes, including the pong
+// and close frames sent in response to the peer, with an internal mutex, so
+// callers do not need to take any special precautions.
+package gobwas
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// Wrap wraps a server-side net.Conn upgraded by ws.Upgrade or ws.UpgradeHTTP
+// from github.com/gobwas/ws as a wrapper.Conn that can be passed to
+// wrapper.Server.Accept. hs is the handshake returned by the upgrade; messages
+// are encoded with the codec registered for its subprotocol (see
+// wrapper.CodecFor). If no subprotocol was negotiated, JSON is used.
+func Wrap(c net.Conn, hs ws.Handshake) wrapper.Conn {
+	return WrapCodec(c, nil, ws.StateServerSide, wrapper.CodecFor(hs.Protocol))
+}
+
+// WrapClient is like Wrap, but wraps a client-side net.Conn dialed by ws.Dial
+// or ws.Dialer.Dial, e.g. to pass it to wrapper.NewClient. br is the
+// *bufio.Reader returned by the dial, which holds any frames received right
+// after the handshake; it may be nil.
+func WrapClient(c net.Conn, br *bufio.Reader, hs ws.Handshake) wrapper.Conn {
+	return WrapCodec(c, br, ws.StateClientSide, wrapper.CodecFor(hs.Protocol))
+}
+
+// WrapCodec wraps c as a wrapper.Conn that encodes messages with the given
+// codec. state is ws.StateServerSide for upgraded connections and
+// ws.StateClientSide for dialed connections. If br is not nil, frames are read
+// from br instead of c.
+func WrapCodec(
+	c net.Conn, br *bufio.Reader, state ws.State, codec wrapper.Codec,
+) wrapper.Conn {
+	var src io.Reader = c
+	if br != nil {
+		src = br
+	}
+	return &conn{
+		Conn: c, src: src, state: state, codec: codec,
+		pongs: make(chan string, 1),
+	}
+}
+
+// conn implements the wrapper.CodecConn interface for a net.Conn speaking the
+// WebSocket protocol through github.com/gobwas/ws.
+type conn struct {
+	net.Conn
+	src       io.Reader // reader of inbound frames
+	state     ws.State
+	codec     wrapper.Codec
+	writeMu   sync.Mutex
+	readLimit atomic.Int64
+	closeSent atomic.Bool
+	pingNonce atomic.Uint64
+	pongs     chan string // payload of the most recent pong
+}
+
+// Codec returns the codec used to encode messages
+func (c *conn) Codec() wrapper.Codec {
+	return c.codec
+}
+
+// ReadMessage reads a single message from the connection, followed by its
+// binary attachments (see wrapper.Message.Attachments). Control frames
+// received in the meantime are handled. If the peer closes the connection,
+// a wrapper.CloseError with its status code and reason is returned. It
+// respects context cancellation by expiring the read deadline, causing the
+// blocking read to unblock.
+func (c *conn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
+	// If context can be cancelled
+	if ctx.Done() != nil {
+		// Spawn a goroutine to monitor cancellation / ReadMessage completion
+		// Note: ReadMessage returns after the goroutine completes, so that the
+		// deadline is cleared before the next read
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				// Interrupt the blocking read by setting a read deadline in the
+				// past.
+				_ = c.Conn.SetReadDeadline(time.Unix(0, 1))
+				// Wait for ReadMessage to finish (probably return an error)
+				<-done
+				// Clear the deadline by setting it to the zero value
+				_ = c.Conn.SetReadDeadline(time.Time{})
+			case <-done:
+				// Read completed normally
+			}
+		}()
+	}
+
+	// Note: message type is ignored
+	data, _, err := c.readData()
+	if err == nil {
+		err = c.codec.UnmarshalMessage(data, msg)
+	}
+	for i := 0; err == nil && i < len(msg.Attachments); i++ {
+		var op ws.OpCode
+		msg.Attachments[i], op, err = c.readData()
+		if err == nil && op != ws.OpBinary {
+			err = errors.New("expected binary attachment")
+		}
+	}
+	// Prioritize context cancellation error
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// readData reads the next text or binary WebSocket message and returns its
+// payload and opcode. Control frames are handled by handleControl.
+func (c *conn) readData() ([]byte, ws.OpCode, error) {
+	limit := c.readLimit.Load()
+	rd := wsutil.Reader{
+		Source:         c.src,
+		State:          c.state,
+		CheckUTF8:      true,
+		MaxFrameSize:   limit,
+		OnIntermediate: c.handleControl,
+	}
+	for {
+		hdr, err := rd.NextFrame()
+		if errors.Is(err, wsutil.ErrFrameTooLarge) {
+			return nil, 0, wrapper.ErrMessageTooBig
+		} else if err != nil {
+			return nil, 0, err
+		}
+		if hdr.OpCode.IsControl() {
+			if err := c.handleControl(hdr, &rd); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if limit <= 0 {
+			data, err := io.ReadAll(&rd)
+			return data, hdr.OpCode, err
+		}
+		// The frame size is limited by rd, but a fragmented message may still
+		// exceed the limit
+		data, err := io.ReadAll(io.LimitReader(&rd, limit+1))
+		if err == nil && int64(len(data)) > limit {
+			err = wrapper.ErrMessageTooBig
+		}
+		return data, hdr.OpCode, err
+	}
+}
+
+// handleControl handles a control frame read from r. Pings are answered, pongs
+// are passed to Ping, and close frames are echoed and reported as a
+// wrapper.CloseError.
+func (c *conn) handleControl(hdr ws.Header, r io.Reader) error {
+	if hdr.OpCode == ws.OpPong {
+		payload, err := io.ReadAll(r)
+		if err == nil {
+			c.handlePong(string(payload))
+		}
+		return err
+	}
+	if hdr.OpCode == ws.OpClose {
+		payload, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return c.handleClose(payload)
+	}
+	return wsutil.ControlHandler{
+		Src:   r,
+		Dst:   controlWriter{c},
+		State: c.state,
+		// Payload already unmasked by wsutil.Reader
+		DisableSrcCiphering: true,
+	}.Handle(hdr)
+}
+
+// handleClose echoes the status code of a close frame received from the peer,
+// unless a close frame was already sent, and reports it as a
+// wrapper.CloseError. The peer may not wait for the echo, so failing to send
+// it is not an error.
+func (c *conn) handleClose(payload []byte) error {
+	code, reason := ws.ParseCloseFrameData(payload)
+	if len(payload) < 2 {
+		code = ws.StatusNoStatusRcvd
+	} else if err := ws.CheckCloseFrameData(code, reason); err != nil {
+		if !c.closeSent.Swap(true) {
+			body := ws.NewCloseFrameBody(ws.StatusProtocolError, err.Error())
+			_ = c.writeControl(ws.OpClose, body, time.Now().Add(5*time.Second))
+		}
+		return err
+	}
+	if !c.closeSent.Swap(true) {
+		_ = c.writeControl(
+			ws.OpClose, payload[:min(len(payload), 2)],
+			time.Now().Add(5*time.Second),
+		)
+	}
+	return wrapper.CloseError{Code: wrapper.StatusCode(code), Reason: reason}
+}
+
+// handlePong replaces any unreceived pong with payload. It is called by the
+// goroutine reading from the connection.
+func (c *conn) handlePong(payload string) {
+	select {
+	case <-c.pongs:
+	default:
+	}
+	c.pongs <- payload
+}
+
+// controlWriter writes the control frames sent in response to the peer. It
+// holds the write mutex, so they do not interleave with other writes.
+type controlWriter struct {
+	c *conn
+}
+
+func (w controlWriter) Write(p []byte) (int, error) {
+	w.c.writeMu.Lock()
+	defer w.c.writeMu.Unlock()
+	return w.c.Conn.Write(p)
+}
+
+// WriteMessage encodes msg and sends it as a WebSocket text frame, or as a
+// binary frame if the codec is binary. Its attachments are sent as binary
+// frames immediately after the message.
+// A write mutex ensures at most one writer is active at a time.
+func (c *conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
+	data, err := msg.Encode(c.codec)
+	if err != nil {
+		return err
+	}
+	op := ws.OpText
+	if c.codec.Binary() {
+		op = ws.OpBinary
+	}
+	c.writeMu.Lock()
+	if ctx.Done() == nil {
+		defer c.writeMu.Unlock()
+	} else {
+		// Spawn a goroutine to monitor cancellation / WriteMessage completion
+		// Note: `writeMu` unlocked when goroutine completes
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Interrupt the blocking write by setting a deadline in the past.
+				_ = c.Conn.SetWriteDeadline(time.Unix(0, 1))
+				// Wait for WriteMessage to finish
+				<-done
+				// Clear the deadline by setting it to the zero value
+				_ = c.Conn.SetWriteDeadline(time.Time{})
+			case <-done:
+				// Write completed normally
+			}
+			c.writeMu.Unlock()
+		}()
+	}
+	writeErr := wsutil.WriteMessage(c.Conn, c.state, op, data)
+	for i := 0; writeErr == nil && i < len(msg.Attachments); i++ {
+		writeErr = wsutil.WriteMessage(
+			c.Conn, c.state, ws.OpBinary, msg.Attachments[i],
+		)
+	}
+	// Prioritize context cancellation error
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return writeErr
+}
+
+// SetReadLimit sets the maximum size in bytes of a WebSocket message read from
+// the connection (see wrapper.Limits). If a larger message is received,
+// ReadMessage returns wrapper.ErrMessageTooBig. By default, there is no limit.
+func (c *conn) SetReadLimit(n int64) {
+	c.readLimit.Store(n)
+}
+
+// Ping sends a WebSocket ping and waits for the matching pong or for ctx to be
+// done. It is used by the heartbeat (see wrapper.Server.SetHeartbeat). Pongs
+// are only received while ReadMessage is being called.
+func (c *conn) Ping(ctx context.Context) error {
+	nonce := strconv.FormatUint(c.pingNonce.Add(1), 10)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	err := c.writeControl(ws.OpPing, []byte(nonce), deadline)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case pong := <-c.pongs:
+			if pong == nonce {
+				return nil
+			} // else pong for an earlier ping
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeControl writes a control frame with the given payload. The write fails
+// if it does not complete before deadline.
+func (c *conn) writeControl(op ws.OpCode, payload []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.Conn.SetWriteDeadline(deadline)
+	defer c.Conn.SetWriteDeadline(time.Time{})
+	return wsutil.WriteMessage(c.Conn, c.state, op, payload)
+}
+
+// Close sends a WebSocket close frame with the given status code and reason,
+// unless one was already sent in response to the peer, then closes the
+// underlying network connection.
+func (c *conn) Close(statusCode wrapper.StatusCode, reason string) error {
+	var err error
+	if !c.closeSent.Swap(true) {
+		body := ws.NewCloseFrameBody(ws.StatusCode(statusCode), reason)
+		err = c.writeControl(ws.OpClose, body, time.Now().Add(5*time.Second))
+	}
+	// Prioritize writeControl error
+	if closeErr := c.Conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// CloseNow closes the underlying network connection immediately without
+// attempting a WebSocket close handshake.
+func (c *conn) CloseNow() error {
+	return c.Conn.Close()
+}
@@ -0,0 +1,16 @@
+module github.com/bminer/ws-server-wrapper-go/adapters/gobwas
+
+go 1.23.4
+
+require (
+	github.com/bminer/ws-server-wrapper-go v0.0.0
+	github.com/gobwas/ws v1.4.0
+)
+
+require (
+	github.com/gobwas/httphead v0.1.0 // indirect
+	github.com/gobwas/pool v0.2.1 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+)
+
+replace github.com/bminer/ws-server-wrapper-go => ../..
@@ -10,8 +10,11 @@ package gorilla
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	wrapper "github.com/bminer/ws-server-wrapper-go"
@@ -20,20 +23,50 @@ import (
 
 // Wrap wraps a *websocket.Conn from github.com/gorilla/websocket as a
 // wrapper.Conn that can be passed to wrapper.Server.Accept or
-// wrapper.Client.Bind.
+// wrapper.Client.Bind. Messages are encoded with the codec registered for the
+// negotiated subprotocol (see wrapper.CodecFor); if no subprotocol was
+// negotiated, JSON is used.
 func Wrap(c *websocket.Conn) wrapper.Conn {
-	return &conn{Conn: c}
+	return WrapCodec(c, wrapper.CodecFor(c.Subprotocol()))
 }
 
-// conn implements the wrapper.Conn interface for a gorilla *websocket.Conn.
+// WrapCodec is like Wrap, but messages are encoded with the given codec.
+//
+// The pong handler of c is replaced by one that receives the pongs of
+// heartbeat pings (see wrapper.Server.SetHeartbeat) and then calls the
+// previous pong handler, so set any pong handler of your own before wrapping
+// c. Setting a pong handler afterwards breaks the heartbeat.
+func WrapCodec(c *websocket.Conn, codec wrapper.Codec) wrapper.Conn {
+	wc := &conn{Conn: c, codec: codec, pongs: make(chan string, 1)}
+	prev := c.PongHandler()
+	c.SetPongHandler(func(appData string) error {
+		wc.handlePong(appData)
+		return prev(appData)
+	})
+	return wc
+}
+
+// conn implements the wrapper.CodecConn interface for a gorilla
+// *websocket.Conn.
 type conn struct {
 	*websocket.Conn
-	writeMu sync.Mutex
+	codec     wrapper.Codec
+	writeMu   sync.Mutex
+	pingNonce atomic.Uint64
+	pongs     chan string // payload of the most recent pong
+}
+
+// Codec returns the codec used to encode messages
+func (c *conn) Codec() wrapper.Codec {
+	return c.codec
 }
 
-// ReadMessage reads a single JSON message from the connection. It respects
+// ReadMessage reads a single message from the connection, followed by its
+// binary attachments (see wrapper.Message.Attachments). It respects
 // context cancellation by expiring the read deadline, causing the underlying
-// gorilla ReadMessage call to unblock.
+// gorilla ReadMessage call to unblock. If the remote end closed the
+// connection, a wrapper.CloseError with its status code and reason is
+// returned.
 func (c *conn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
 	// If context can be cancelled
 	if ctx.Done() != nil {
@@ -58,24 +91,45 @@ func (c *conn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
 
 	// Note: message type is ignored
 	_, data, err := c.Conn.ReadMessage()
-	if err != nil {
-		// Prioritize context cancellation error
-		if ctx.Err() != nil {
-			return ctx.Err()
+	if err == nil {
+		err = c.codec.UnmarshalMessage(data, msg)
+	}
+	for i := 0; err == nil && i < len(msg.Attachments); i++ {
+		var typ int
+		typ, msg.Attachments[i], err = c.Conn.ReadMessage()
+		if err == nil && typ != websocket.BinaryMessage {
+			err = errors.New("expected binary attachment")
 		}
-		return err
 	}
-	return json.Unmarshal(data, msg)
+	// Prioritize context cancellation error
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		return wrapper.CloseError{
+			Code: wrapper.StatusCode(ce.Code), Reason: ce.Text,
+		}
+	} else if errors.Is(err, websocket.ErrReadLimit) {
+		return fmt.Errorf("%w: %w", wrapper.ErrMessageTooBig, err)
+	}
+	return err
 }
 
-// WriteMessage encodes msg as JSON and sends it as a WebSocket text frame.
+// WriteMessage encodes msg and sends it as a WebSocket text frame, or as a
+// binary frame if the codec is binary. Its attachments are sent as binary
+// frames immediately after the message.
 // A write mutex ensures at most one writer is active at a time, as required by
 // gorilla/websocket.
 func (c *conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
-	data, err := json.Marshal(msg)
+	data, err := msg.Encode(c.codec)
 	if err != nil {
 		return err
 	}
+	typ := websocket.TextMessage
+	if c.codec.Binary() {
+		typ = websocket.BinaryMessage
+	}
 	c.writeMu.Lock()
 	if ctx.Done() == nil {
 		defer c.writeMu.Unlock()
@@ -99,7 +153,12 @@ func (c *conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
 			c.writeMu.Unlock()
 		}()
 	}
-	writeErr := c.Conn.WriteMessage(websocket.TextMessage, data)
+	writeErr := c.Conn.WriteMessage(typ, data)
+	for i := 0; writeErr == nil && i < len(msg.Attachments); i++ {
+		writeErr = c.Conn.WriteMessage(
+			websocket.BinaryMessage, msg.Attachments[i],
+		)
+	}
 	// Prioritize context cancellation error
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -107,6 +166,50 @@ func (c *conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
 	return writeErr
 }
 
+// SetReadLimit sets the maximum size in bytes of a WebSocket message read from
+// the connection (see wrapper.Limits). If a larger message is received, the
+// connection is closed with StatusMessageTooBig and ReadMessage returns an
+// error wrapping wrapper.ErrMessageTooBig. By default,
+// github.com/gorilla/websocket has no limit.
+func (c *conn) SetReadLimit(n int64) {
+	c.Conn.SetReadLimit(n)
+}
+
+// Ping sends a WebSocket ping and waits for the matching pong or for ctx to be
+// done. It is used by the heartbeat (see wrapper.Server.SetHeartbeat). Pongs
+// are only received while ReadMessage is being called.
+func (c *conn) Ping(ctx context.Context) error {
+	nonce := strconv.FormatUint(c.pingNonce.Add(1), 10)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	err := c.Conn.WriteControl(websocket.PingMessage, []byte(nonce), deadline)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case pong := <-c.pongs:
+			if pong == nonce {
+				return nil
+			} // else pong for an earlier ping
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handlePong passes a pong to Ping. It is called by the pong handler of the
+// connection on the goroutine reading from it and replaces any unreceived pong.
+func (c *conn) handlePong(appData string) {
+	select {
+	case <-c.pongs:
+	default:
+	}
+	c.pongs <- appData
+}
+
 // Close performs the WebSocket close handshake with the given status code and
 // reason, then closes the underlying network connection. WriteControl is used
 // for the close frame because gorilla allows it to be called concurrently with
@@ -1,8 +1,13 @@
 package gorilla
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
 
 	wrapper "github.com/bminer/ws-server-wrapper-go"
 	"github.com/gorilla/websocket"
@@ -39,3 +44,119 @@ func Example() {
 	// Start the HTTP server
 	log.Fatal(http.ListenAndServe("localhost:8080", h))
 }
+
+func ExampleHandler() {
+	wsServer := wrapper.NewServer()
+	wsServer.On("whoami", func(ctx context.Context) (any, error) {
+		return wrapper.ClientFromContext(ctx).Get("user"), nil
+	})
+
+	// Reject requests without a user and attach the user to the client
+	h := Handler(wsServer, HandlerOptions{
+		BeforeUpgrade: func(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+			user := r.Header.Get("X-User")
+			if user == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return nil, false
+			}
+			return map[string]any{"user": user}, true
+		},
+	})
+	log.Fatal(http.ListenAndServe("localhost:8080", h))
+}
+
+// TestPongHandlerChained verifies that the heartbeat receives pongs and that
+// the pong handler set before wrapping the connection is still called.
+func TestPongHandlerChained(t *testing.T) {
+	conns := make(chan wrapper.Conn, 1)
+	pongs := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c.SetPongHandler(func(appData string) error {
+			pongs <- appData
+			return nil
+		})
+		conns <- Wrap(c)
+	}))
+	defer ts.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(ts.URL, "http"), nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go func() {
+		// Reading answers pings
+		for {
+			if _, _, err := client.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	conn := <-conns
+	defer conn.CloseNow()
+	go func() {
+		// Reading receives pongs
+		var msg wrapper.Message
+		for conn.ReadMessage(context.Background(), &msg) == nil {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := conn.(wrapper.PingConn).Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-pongs:
+	case <-time.After(time.Second):
+		t.Fatal("previous pong handler was not called")
+	}
+}
+
+// TestReadLimit verifies that a message exceeding Limits.MaxMessageSize is
+// rejected by the read limit and closes the connection with
+// StatusMessageTooBig on both ends.
+func TestReadLimit(t *testing.T) {
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.SetLimits(wrapper.Limits{MaxMessageSize: 64})
+	closed := make(chan wrapper.StatusCode, 2)
+	onClose := func(_ *wrapper.Client, status wrapper.StatusCode, _ string, _ bool) {
+		closed <- status
+	}
+	server.On("close", onClose)
+	ts := httptest.NewServer(Handler(server, HandlerOptions{}))
+	defer ts.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(ts.URL, "http"), nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := wrapper.NewClient(nil)
+	client.On("close", onClose)
+	client.Bind(Wrap(c))
+	defer client.Close(wrapper.StatusNormalClosure, "")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.Emit(ctx, "upload", strings.Repeat("a", 100)); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case status := <-closed:
+			if status != wrapper.StatusMessageTooBig {
+				t.Fatalf("expected status %d, got %d", wrapper.StatusMessageTooBig, status)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("connection was not closed")
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package gorilla
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/gorilla/websocket"
+)
+
+// HandlerOptions configures the http.Handler returned by Handler. The zero
+// value accepts same-origin connections without compression. No subprotocol
+// is negotiated, so messages are encoded as JSON.
+type HandlerOptions struct {
+	// OriginPatterns lists the host patterns of other origins that are
+	// allowed to connect, matched with path.Match (e.g. "*.example.com"). The
+	// request host is always allowed.
+	OriginPatterns []string
+	// Subprotocols lists the subprotocols supported by the server in order of
+	// preference. The negotiated subprotocol selects the codec (see Wrap); use
+	// wrapper.Codecs to offer all registered codecs.
+	Subprotocols []string
+	// ReadLimit is the maximum size of an inbound WebSocket message in bytes.
+	// If zero, there is no limit. The MaxMessageSize of the server's
+	// wrapper.Limits takes precedence if set.
+	ReadLimit int64
+	// EnableCompression negotiates the permessage-deflate WebSocket extension
+	EnableCompression bool
+	// BeforeUpgrade, if set, is called before the request is upgraded, e.g.
+	// to authenticate it with its headers or cookies. The returned data is
+	// set on the wrapper.Client (see wrapper.Client.Set) before the server's
+	// "open" event handlers fire. If ok is false, the request is rejected;
+	// BeforeUpgrade must write the HTTP response in that case.
+	BeforeUpgrade func(w http.ResponseWriter, r *http.Request) (data map[string]any, ok bool)
+	// Logger logs errors that occur while upgrading and accepting
+	// connections. If nil, slog.Default is used.
+	Logger *slog.Logger
+}
+
+// Handler returns an http.Handler that upgrades requests to WebSocket
+// connections and passes them to server.Accept
+func Handler(server *wrapper.Server, opts HandlerOptions) http.Handler {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	upgrader := &websocket.Upgrader{
+		Subprotocols:      opts.Subprotocols,
+		EnableCompression: opts.EnableCompression,
+	}
+	if len(opts.OriginPatterns) > 0 {
+		upgrader.CheckOrigin = checkOrigin(opts.OriginPatterns)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		if opts.BeforeUpgrade != nil {
+			var ok bool
+			if data, ok = opts.BeforeUpgrade(w, r); !ok {
+				return
+			}
+		}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// upgrader.Upgrade already wrote the HTTP error response
+			logger.Warn("WebSocket upgrade failed",
+				"remoteAddr", r.RemoteAddr, "error", err,
+			)
+			return
+		}
+		if opts.ReadLimit != 0 {
+			c.SetReadLimit(opts.ReadLimit)
+		}
+		err = server.AcceptWith(Wrap(c), func(client *wrapper.Client) {
+			for key, value := range data {
+				client.Set(key, value)
+			}
+		})
+		if err != nil {
+			// server.AcceptWith already closed the conn
+			logger.Warn("WebSocket connection not accepted",
+				"remoteAddr", r.RemoteAddr, "error", err,
+			)
+		}
+	})
+}
+
+// checkOrigin returns a websocket.Upgrader CheckOrigin function that allows
+// requests without an Origin header, requests from the request host, and
+// requests from a host matching one of patterns.
+func checkOrigin(patterns []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(u.Host)
+		if host == strings.ToLower(r.Host) {
+			return true
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
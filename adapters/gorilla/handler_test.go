@@ -0,0 +1,99 @@
+package gorilla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/gorilla/websocket"
+)
+
+// TestCheckOrigin verifies that requests from the request host and from hosts
+// matching the origin patterns are allowed.
+func TestCheckOrigin(t *testing.T) {
+	check := checkOrigin([]string{"*.example.com"})
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://server.test", true},
+		{"https://SERVER.test", true},
+		{"https://app.example.com", true},
+		{"https://example.com", false},
+		{"https://evil.test", false},
+		{"://bad", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://server.test/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if allowed := check(r); allowed != test.allowed {
+			t.Errorf("origin %q: expected allowed to be %v", test.origin, test.allowed)
+		}
+	}
+}
+
+// TestHandler verifies that Handler rejects requests from other origins and
+// requests rejected by BeforeUpgrade, and that the data returned by
+// BeforeUpgrade is set on the client.
+func TestHandler(t *testing.T) {
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.On("whoami", func(ctx context.Context) (any, error) {
+		return wrapper.ClientFromContext(ctx).Get("user"), nil
+	})
+	ts := httptest.NewServer(Handler(server, HandlerOptions{
+		OriginPatterns: []string{"*.example.com"},
+		BeforeUpgrade: func(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+			user := r.Header.Get("X-User")
+			if user == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return nil, false
+			}
+			return map[string]any{"user": user}, true
+		},
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	tests := []struct {
+		origin string
+		user   string
+		status int
+	}{
+		{"https://app.example.com", "alice", http.StatusSwitchingProtocols},
+		{"", "bob", http.StatusSwitchingProtocols},
+		{"https://evil.test", "alice", http.StatusForbidden},
+		{"https://app.example.com", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		if test.user != "" {
+			header.Set("X-User", test.user)
+		}
+		c, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if resp == nil || resp.StatusCode != test.status {
+			t.Fatalf("%+v: expected status %d, got %v (%v)", test, test.status, resp, err)
+		}
+		if err != nil {
+			continue
+		}
+		client := wrapper.NewClient(Wrap(c))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		user, err := client.Request(ctx, "whoami")
+		cancel()
+		client.Close(wrapper.StatusNormalClosure, "")
+		if err != nil || user != test.user {
+			t.Fatalf("expected user %q, got %v (%v)", test.user, user, err)
+		}
+	}
+}
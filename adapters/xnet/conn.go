@@ -0,0 +1,222 @@
+// This package enables the use of the golang.org/x/net/websocket package with
+// the ws-server-wrapper library. It provides an adapter between a
+// *websocket.Conn from golang.org/x/net/websocket and a Conn in the
+// ws-server-wrapper library.
+//
+// Note: golang.org/x/net/websocket always closes connections with status
+// code 1000 (normal closure) and does not report the status code sent by the
+// peer; the peer closing the connection causes ReadMessage to return a
+// wrapper.CloseError with status code wrapper.StatusNoStatusRcvd.
+// It answers pings itself and does not expose pongs, so the heartbeat (see
+// wrapper.Server.SetHeartbeat) uses ws-wrapper protocol pings instead. This
+// adapter serializes all outbound writes with an internal mutex, so callers do
+// not need to take any special precautions.
+package xnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"golang.org/x/net/websocket"
+)
+
+// Wrap wraps a *websocket.Conn from golang.org/x/net/websocket as a
+// wrapper.Conn that can be passed to wrapper.Server.Accept or
+// wrapper.Client.Bind. Messages are encoded with the codec registered for the
+// connection's subprotocol (see wrapper.CodecFor), i.e. the only entry of
+// c.Config().Protocol; for server connections, it must be selected by the
+// websocket.Server Handshake function. Otherwise, JSON is used.
+func Wrap(c *websocket.Conn) wrapper.Conn {
+	var subprotocol string
+	if protocols := c.Config().Protocol; len(protocols) == 1 {
+		subprotocol = protocols[0]
+	}
+	return WrapCodec(c, wrapper.CodecFor(subprotocol))
+}
+
+// WrapCodec is like Wrap, but messages are encoded with the given codec.
+func WrapCodec(c *websocket.Conn, codec wrapper.Codec) wrapper.Conn {
+	return &conn{Conn: c, codec: codec}
+}
+
+// conn implements the wrapper.CodecConn interface for a
+// golang.org/x/net/websocket *websocket.Conn.
+type conn struct {
+	*websocket.Conn
+	codec     wrapper.Codec
+	writeMu   sync.Mutex
+	readLimit atomic.Int64
+}
+
+// frame is a WebSocket frame sent or received with frameCodec
+type frame struct {
+	data        []byte
+	payloadType byte
+}
+
+// frameCodec sends and receives frames, keeping their payload type
+var frameCodec = websocket.Codec{
+	Marshal: func(v any) ([]byte, byte, error) {
+		f := v.(*frame)
+		return f.data, f.payloadType, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v any) error {
+		f := v.(*frame)
+		f.data, f.payloadType = data, payloadType
+		return nil
+	},
+}
+
+// Codec returns the codec used to encode messages
+func (c *conn) Codec() wrapper.Codec {
+	return c.codec
+}
+
+// ReadMessage reads a single message from the connection, followed by its
+// binary attachments (see wrapper.Message.Attachments). If the peer closes the
+// connection, a wrapper.CloseError is returned. It respects context
+// cancellation by expiring the read deadline, causing the underlying blocking
+// read to unblock.
+func (c *conn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
+	// If context can be cancelled
+	if ctx.Done() != nil {
+		// Spawn a goroutine to monitor cancellation / ReadMessage completion
+		// Note: ReadMessage returns after the goroutine completes, so that the
+		// deadline is cleared before the next read
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				// Interrupt the blocking read by setting a read deadline in the
+				// past.
+				_ = c.Conn.SetReadDeadline(time.Unix(0, 1))
+				// Wait for ReadMessage to finish (probably return an error)
+				<-done
+				// Clear the deadline by setting it to the zero value
+				_ = c.Conn.SetReadDeadline(time.Time{})
+			case <-done:
+				// Read completed normally
+			}
+		}()
+	}
+
+	// MaxPayloadBytes is only accessed by the reading goroutine
+	if n := c.readLimit.Load(); n > 0 {
+		c.Conn.MaxPayloadBytes = int(n)
+	}
+	// Note: message type is ignored
+	var f frame
+	err := frameCodec.Receive(c.Conn, &f)
+	if err == nil {
+		err = c.codec.UnmarshalMessage(f.data, msg)
+	}
+	for i := 0; err == nil && i < len(msg.Attachments); i++ {
+		err = frameCodec.Receive(c.Conn, &f)
+		msg.Attachments[i] = f.data
+		if err == nil && f.payloadType != websocket.BinaryFrame {
+			err = errors.New("expected binary attachment")
+		}
+	}
+	// Prioritize context cancellation error
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	} else if errors.Is(err, io.EOF) {
+		// The status code sent by the peer is not available
+		return wrapper.CloseError{Code: wrapper.StatusNoStatusRcvd}
+	} else if errors.Is(err, websocket.ErrFrameTooLarge) {
+		return fmt.Errorf("%w: %w", wrapper.ErrMessageTooBig, err)
+	}
+	return err
+}
+
+// WriteMessage encodes msg and sends it as a WebSocket text frame, or as a
+// binary frame if the codec is binary. Its attachments are sent as binary
+// frames immediately after the message.
+// A write mutex ensures that the frames of different messages do not
+// interleave.
+func (c *conn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
+	data, err := msg.Encode(c.codec)
+	if err != nil {
+		return err
+	}
+	payloadType := byte(websocket.TextFrame)
+	if c.codec.Binary() {
+		payloadType = websocket.BinaryFrame
+	}
+	c.writeMu.Lock()
+	if ctx.Done() == nil {
+		defer c.writeMu.Unlock()
+	} else {
+		// Spawn a goroutine to monitor cancellation / WriteMessage completion
+		// Note: `writeMu` unlocked when goroutine completes
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Interrupt the blocking write by setting a deadline in the past.
+				_ = c.Conn.SetWriteDeadline(time.Unix(0, 1))
+				// Wait for WriteMessage to finish
+				<-done
+				// Clear the deadline by setting it to the zero value
+				_ = c.Conn.SetWriteDeadline(time.Time{})
+			case <-done:
+				// Write completed normally
+			}
+			c.writeMu.Unlock()
+		}()
+	}
+	writeErr := frameCodec.Send(c.Conn, &frame{data, payloadType})
+	for i := 0; writeErr == nil && i < len(msg.Attachments); i++ {
+		writeErr = frameCodec.Send(
+			c.Conn, &frame{msg.Attachments[i], websocket.BinaryFrame},
+		)
+	}
+	// Prioritize context cancellation error
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return writeErr
+}
+
+// SetReadLimit sets the maximum size in bytes of a WebSocket frame read from
+// the connection (see wrapper.Limits). If a larger frame is received,
+// ReadMessage returns an error wrapping wrapper.ErrMessageTooBig and
+// websocket.ErrFrameTooLarge. By default,
+// golang.org/x/net/websocket uses websocket.DefaultMaxPayloadBytes.
+func (c *conn) SetReadLimit(n int64) {
+	c.readLimit.Store(n)
+}
+
+// Close sends a WebSocket close frame and closes the underlying network
+// connection. golang.org/x/net/websocket always sends status code 1000
+// (normal closure), so statusCode and reason are ignored.
+func (c *conn) Close(statusCode wrapper.StatusCode, reason string) error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.Conn.Close()
+}
+
+// CloseNow closes the underlying network connection immediately. The write
+// deadline is expired first, so that no close frame is sent.
+func (c *conn) CloseNow() error {
+	_ = c.Conn.SetWriteDeadline(time.Unix(0, 1))
+	err := c.Conn.Close()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		// Close frame not sent, as intended
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package xnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"golang.org/x/net/websocket"
+)
+
+func Example() {
+	// Create ws-wrapper-server and "echo" event handler. Note that the event
+	// handler function may optionally have a context as its first argument,
+	// it must always return 2 values, and the second value must implement
+	// error.
+	wsServer := wrapper.NewServer()
+	wsServer.On("echo", func(s string) (string, error) {
+		// If a ws-server client sends a "echo" request, it will receive the
+		// echoed response.
+		return s, nil
+	})
+
+	// Create HTTP handler that upgrades the HTTP connection to a WebSocket
+	// using the golang.org/x/net/websocket package. The connection is closed
+	// when the handler function returns, so it waits for the client to close.
+	h := websocket.Handler(func(conn *websocket.Conn) {
+		closed := make(chan struct{})
+		// Attach the WebSocket connection to ws-server-wrapper and start
+		// listening for inbound messages.
+		err := wsServer.AcceptWith(Wrap(conn), func(c *wrapper.Client) {
+			c.On("close", func(*wrapper.Client, wrapper.StatusCode, string, bool) {
+				close(closed)
+			})
+		})
+		if err != nil {
+			// wsServer.AcceptWith already closes the conn
+			return
+		}
+		<-closed
+	})
+
+	// Start the HTTP server
+	log.Fatal(http.ListenAndServe("localhost:8080", h))
+}
+
+// pair returns the server and client ends of a WebSocket connection over a
+// loopback HTTP server. Both are closed when the test ends.
+func pair(t *testing.T) (server, client wrapper.Conn) {
+	t.Helper()
+	conns := make(chan wrapper.Conn, 1)
+	release := make(chan struct{})
+	ts := httptest.NewServer(websocket.Handler(func(c *websocket.Conn) {
+		conns <- Wrap(c)
+		// The connection is closed when the handler returns
+		<-release
+	}))
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { close(release) })
+
+	c, err := websocket.Dial(
+		"ws"+strings.TrimPrefix(ts.URL, "http"), "", ts.URL,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Wrap(c)
+	t.Cleanup(func() { client.CloseNow() })
+	select {
+	case server = <-conns:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not accepted")
+	}
+	t.Cleanup(func() { server.CloseNow() })
+	return server, client
+}
+
+// event returns an event message with the given arguments
+func event(args ...string) *wrapper.Message {
+	msg := &wrapper.Message{}
+	for _, arg := range args {
+		msg.Arguments = append(msg.Arguments, json.RawMessage(arg))
+	}
+	return msg
+}
+
+// TestReadWriteMessage verifies that messages and their attachments are sent
+// in both directions.
+func TestReadWriteMessage(t *testing.T) {
+	server, client := pair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, ends := range [][2]wrapper.Conn{{client, server}, {server, client}} {
+		sent := event(`"upload"`, `{"_placeholder":true,"num":0}`)
+		sent.Attachments = [][]byte{{1, 2, 3}}
+		if err := ends[0].WriteMessage(ctx, sent); err != nil {
+			t.Fatal(err)
+		}
+		var msg wrapper.Message
+		if err := ends[1].ReadMessage(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.EventName() != "upload" || len(msg.Attachments) != 1 ||
+			!bytes.Equal(msg.Attachments[0], []byte{1, 2, 3}) {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	}
+}
+
+// TestReadMessageCancel verifies that cancelling the context interrupts
+// ReadMessage and that the connection can still be read afterwards.
+func TestReadMessageCancel(t *testing.T) {
+	server, client := pair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var msg wrapper.Message
+	err := server.ReadMessage(ctx, &msg)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := client.WriteMessage(ctx, event(`"ping"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReadMessage(ctx, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.EventName() != "ping" {
+		t.Fatalf("expected ping event, got %+v", msg)
+	}
+}
+
+// TestWriteMessageCancel verifies that cancelling the context interrupts a
+// WriteMessage blocked by a peer that does not read.
+func TestWriteMessageCancel(t *testing.T) {
+	server, _ := pair(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	msg := event(`"upload"`, `{"_placeholder":true,"num":0}`)
+	msg.Attachments = [][]byte{make([]byte, 1<<20)}
+	var err error
+	for err == nil {
+		err = server.WriteMessage(ctx, msg)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+// TestClose verifies that the peer's ReadMessage returns a
+// wrapper.CloseError, without the status code since golang.org/x/net/websocket
+// does not report it.
+func TestClose(t *testing.T) {
+	server, client := pair(t)
+	if err := server.Close(4001, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var msg wrapper.Message
+	err := client.ReadMessage(ctx, &msg)
+	var closeErr wrapper.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != wrapper.StatusNoStatusRcvd {
+		t.Fatalf("expected close error, got %v", err)
+	}
+}
+
+// TestReadLimit verifies that messages and attachments larger than the read
+// limit are rejected.
+func TestReadLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for name, msg := range map[string]*wrapper.Message{
+		"message":    event(`"` + strings.Repeat("a", 100) + `"`),
+		"attachment": event(`"upload"`, `{"_placeholder":true,"num":0}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, client := pair(t)
+			server.(wrapper.ReadLimitConn).SetReadLimit(64)
+			if len(msg.Arguments) > 1 {
+				msg.Attachments = [][]byte{make([]byte, 100)}
+			}
+			if err := client.WriteMessage(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+			var read wrapper.Message
+			err := server.ReadMessage(ctx, &read)
+			if !errors.Is(err, websocket.ErrFrameTooLarge) {
+				t.Fatalf("expected frame too large, got %v", err)
+			}
+		})
+	}
+}
+
+// TestReadLimitClose verifies that a message exceeding Limits.MaxMessageSize
+// is rejected by the read limit and closes the Client with
+// StatusMessageTooBig.
+func TestReadLimitClose(t *testing.T) {
+	serverConn, client := pair(t)
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.SetLimits(wrapper.Limits{MaxMessageSize: 64})
+	closed := make(chan wrapper.StatusCode, 1)
+	server.On("close", func(_ *wrapper.Client, status wrapper.StatusCode, _ string, _ bool) {
+		closed <- status
+	})
+	if err := server.Accept(serverConn); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg := event(`"upload"`, `"`+strings.Repeat("a", 100)+`"`)
+	if err := client.WriteMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case status := <-closed:
+		if status != wrapper.StatusMessageTooBig {
+			t.Fatalf("expected status %d, got %d", wrapper.StatusMessageTooBig, status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/bminer/ws-server-wrapper-go/adapters/xnet
+
+go 1.23.4
+
+require (
+	github.com/bminer/ws-server-wrapper-go v0.0.0
+	golang.org/x/net v0.43.0
+)
+
+replace github.com/bminer/ws-server-wrapper-go => ../..
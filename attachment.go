@@ -0,0 +1,92 @@
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// placeholder replaces a []byte argument that is sent as a binary attachment.
+// Num is the index of the attachment in Message.Attachments.
+type placeholder struct {
+	Placeholder bool `json:"_placeholder"`
+	Num         int  `json:"num"`
+}
+
+var placeholderPrefix = []byte(`{"_placeholder":true`)
+
+// encodePlaceholder returns the encoded placeholder for attachment num
+func encodePlaceholder(num int) []byte {
+	data, _ := json.Marshal(placeholder{Placeholder: true, Num: num})
+	return data
+}
+
+// parsePlaceholder returns the attachment index of an encoded argument that is
+// a placeholder for a binary attachment. Only the exact encoding returned by
+// encodePlaceholder is a placeholder, so that other arguments of the same shape
+// can be escaped (see escapePlaceholder).
+func parsePlaceholder(data []byte) (int, bool) {
+	if !bytes.HasPrefix(data, placeholderPrefix) {
+		return 0, false
+	}
+	var p placeholder
+	if err := json.Unmarshal(data, &p); err != nil || !p.Placeholder ||
+		!bytes.Equal(data, encodePlaceholder(p.Num)) {
+		return 0, false
+	}
+	return p.Num, true
+}
+
+// escapePlaceholder escapes an encoded argument that is not an attachment but
+// would be mistaken for a placeholder by the remote end, such as a map with the
+// same keys. The object is encoded with its keys in a different order, so it
+// is decoded as the same value.
+func escapePlaceholder(data []byte) []byte {
+	if num, ok := parsePlaceholder(data); ok {
+		return fmt.Appendf(nil, `{"num":%d,"_placeholder":true}`, num)
+	}
+	return data
+}
+
+// attachmentCodec decodes placeholders for binary attachments into the
+// attachment's bytes. Other values are decoded by the embedded Codec.
+type attachmentCodec struct {
+	Codec
+	attachments [][]byte
+}
+
+// argumentCodec returns the codec used to decode handler arguments that may
+// refer to attachments
+func argumentCodec(codec Codec, attachments [][]byte) Codec {
+	if codec == nil {
+		codec = JSON
+	}
+	if len(attachments) == 0 {
+		return codec
+	}
+	return attachmentCodec{Codec: codec, attachments: attachments}
+}
+
+// Unmarshal decodes data into v. If data is a placeholder and v points to a
+// byte slice or an empty interface, v is set to the attachment.
+func (c attachmentCodec) Unmarshal(data []byte, v any) error {
+	num, ok := parsePlaceholder(data)
+	if !ok || num < 0 || num >= len(c.attachments) {
+		return c.Codec.Unmarshal(data, v)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return c.Codec.Unmarshal(data, v)
+	}
+	elem := rv.Elem()
+	switch {
+	case elem.Kind() == reflect.Slice && elem.Type().Elem().Kind() == reflect.Uint8:
+		elem.SetBytes(c.attachments[num])
+	case elem.Kind() == reflect.Interface && elem.NumMethod() == 0:
+		elem.Set(reflect.ValueOf(c.attachments[num]))
+	default:
+		return c.Codec.Unmarshal(data, v)
+	}
+	return nil
+}
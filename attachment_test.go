@@ -0,0 +1,191 @@
+package wrapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestMessageAttachmentsJSON verifies that the number of attachments is
+// encoded in JSON and that decoding allocates a slot for each attachment.
+func TestMessageAttachmentsJSON(t *testing.T) {
+	msg := Message{
+		Arguments: []json.RawMessage{
+			[]byte(`"upload"`), []byte(`{"_placeholder":true,"num":0}`),
+		},
+		Attachments: [][]byte{{1, 2, 3}},
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"a":["upload",{"_placeholder":true,"num":0}],"b":1}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+
+	var decoded Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Attachments) != 1 || decoded.Attachments[0] != nil {
+		t.Fatalf("expected one empty attachment, got %v", decoded.Attachments)
+	}
+
+	err = json.Unmarshal([]byte(`{"a":["upload"],"b":5}`), &decoded)
+	if err == nil {
+		t.Fatal("expected error for more attachments than arguments")
+	}
+}
+
+// TestReceiveAttachments verifies that handlers receive attachments in place
+// of their placeholders.
+func TestReceiveAttachments(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	server.On("upload", func(name string, data []byte, meta any) (int, error) {
+		if name != "a.bin" || !bytes.Equal(data, []byte{1, 2, 3}) {
+			t.Errorf("unexpected arguments %q %v", name, data)
+		}
+		if b, ok := meta.([]byte); !ok || !bytes.Equal(b, []byte{4}) {
+			t.Errorf("expected []byte meta, got %#v", meta)
+		}
+		return len(data), nil
+	})
+	Handle(server, "size", func(ctx context.Context, data []byte) (int, error) {
+		return len(data), nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{
+			[]byte(`"upload"`), []byte(`"a.bin"`),
+			[]byte(`{"_placeholder":true,"num":1}`),
+			[]byte(`{"_placeholder":true,"num":0}`),
+		},
+		Attachments: [][]byte{{4}, {1, 2, 3}},
+	})
+	resp := conn.waitWritten(t, time.Second)
+	if resp.ResponseError != nil || resp.ResponseData != 3 {
+		t.Fatalf("expected 3, got %+v", resp)
+	}
+
+	reqID2 := 2
+	conn.send(Message{
+		RequestID: &reqID2,
+		Arguments: []json.RawMessage{
+			[]byte(`"size"`), []byte(`{"_placeholder":true,"num":0}`),
+		},
+		Attachments: [][]byte{make([]byte, 10)},
+	})
+	resp = conn.waitWritten(t, time.Second)
+	if resp.ResponseError != nil || resp.ResponseData != 10 {
+		t.Fatalf("expected 10, got %+v", resp)
+	}
+}
+
+// TestSendAttachments verifies that []byte arguments are sent as attachments
+// only when enabled.
+func TestSendAttachments(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := client.Emit(ctx, "file", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	msg := conn.waitWritten(t, time.Second)
+	if len(msg.Attachments) != 0 || string(msg.Arguments[1]) != `"aGk="` {
+		t.Fatalf("expected base64 argument, got %+v", msg)
+	}
+
+	server.SetBinaryAttachments(true)
+	for _, emit := range []func() error{
+		func() error { return client.Emit(ctx, "file", "a", []byte("hi")) },
+		func() error {
+			if errs := server.Emit(ctx, "file", "a", []byte("hi")); len(errs) > 0 {
+				return errs[0]
+			}
+			return nil
+		},
+	} {
+		if err := emit(); err != nil {
+			t.Fatal(err)
+		}
+		msg = conn.waitWritten(t, time.Second)
+		if len(msg.Attachments) != 1 || string(msg.Attachments[0]) != "hi" {
+			t.Fatalf("expected attachment, got %+v", msg)
+		}
+		if string(msg.Arguments[2]) != `{"_placeholder":true,"num":0}` {
+			t.Fatalf("expected placeholder, got %s", msg.Arguments[2])
+		}
+	}
+
+	client.SetBinaryAttachments(false)
+	if err := client.Emit(ctx, "file", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	msg = conn.waitWritten(t, time.Second)
+	if len(msg.Attachments) != 0 {
+		t.Fatalf("expected no attachments, got %+v", msg)
+	}
+}
+
+// TestPlaceholderShapedArguments verifies that arguments that look like
+// placeholders are escaped when sent and are not replaced by attachments when
+// received.
+func TestPlaceholderShapedArguments(t *testing.T) {
+	server := NewServer()
+	server.SetBinaryAttachments(true)
+	conn := newMockConn()
+	received := make(chan map[string]any, 1)
+	server.On("file", func(meta map[string]any, data []byte) error {
+		if string(data) != "hi" {
+			t.Errorf("expected attachment, got %q", data)
+		}
+		received <- meta
+		return nil
+	})
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	meta := map[string]any{"_placeholder": true, "num": 0}
+	if err := client.Emit(context.Background(), "file", meta, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	msg := conn.waitWritten(t, time.Second)
+	if string(msg.Arguments[1]) == `{"_placeholder":true,"num":0}` {
+		t.Fatalf("expected escaped argument, got %s", msg.Arguments[1])
+	}
+	if string(msg.Arguments[2]) != `{"_placeholder":true,"num":0}` {
+		t.Fatalf("expected placeholder, got %s", msg.Arguments[2])
+	}
+
+	// Send the message back through JSON, as the remote end would
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echoed Message
+	if err := json.Unmarshal(data, &echoed); err != nil {
+		t.Fatal(err)
+	}
+	echoed.Attachments = msg.Attachments
+	conn.send(echoed)
+	select {
+	case got := <-received:
+		if got["_placeholder"] != true || got["num"] != 0.0 {
+			t.Fatalf("expected placeholder-shaped map, got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not called")
+	}
+}
@@ -0,0 +1,186 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultBroadcastConcurrency is the default maximum number of clients that an
+// event is written to concurrently when it is sent to multiple clients. See
+// Server.SetBroadcastConcurrency.
+const DefaultBroadcastConcurrency = 16
+
+// ClientResponse is the response to a request sent to a specific client
+type ClientResponse struct {
+	Client *Client
+	Data   any
+	Error  error
+}
+
+// RequestOptions configures a request sent to multiple clients. The zero value
+// sends the request to all clients and waits for every client to respond.
+type RequestOptions struct {
+	// Filter selects the clients that receive the request. If nil, the request
+	// is sent to all clients.
+	Filter func(*Client) bool
+	// Timeout is the overall deadline for collecting responses. Clients that
+	// have not responded when the timeout expires are sent a cancellation. If
+	// zero, only the deadline of the passed context applies.
+	Timeout time.Duration
+	// Responses, if positive, completes the request as soon as this many
+	// clients have responded, successfully or not.
+	Responses int
+	// Quorum, if positive, completes the request as soon as this many clients
+	// have responded successfully. If a quorum can no longer be reached, the
+	// request completes early and an error wrapping ErrQuorumNotReached is
+	// returned.
+	Quorum int
+}
+
+// errResponseNotNeeded is the cancellation cause for requests that are still
+// pending after a multi-client request has completed.
+var errResponseNotNeeded = fmt.Errorf(
+	"%w: enough responses received", context.Canceled,
+)
+
+// emitEvent sends an event on the specified channel to each of clients that is
+// selected by filter. If filter is nil, the event is sent to all clients. The
+// event is validated once and encoded once for each codec used by the
+// clients, then written to the clients concurrently by emitToClients.
+func emitEvent(
+	ctx context.Context,
+	clients []*Client,
+	filter func(*Client) bool,
+	channel string,
+	arguments []any,
+	concurrency int,
+) (errs []ClientError) {
+	if err := checkEmitArguments(channel, arguments); err != nil {
+		errs = append(errs, ClientError{Client: nil, error: err})
+		return
+	}
+	if filter != nil {
+		clients = slices.DeleteFunc(clients, func(c *Client) bool {
+			return !filter(c)
+		})
+	}
+	return emitToClients(ctx, clients, channel,
+		newArgumentEncoder(arguments), concurrency,
+	)
+}
+
+// emitToClients writes an event with the arguments of encoder to each client
+// and returns the errors that occurred. Writes are performed concurrently by
+// at most concurrency goroutines, so a slow client only holds up one of them.
+func emitToClients(
+	ctx context.Context,
+	clients []*Client,
+	channel string,
+	encoder *argumentEncoder,
+	concurrency int,
+) (errs []ClientError) {
+	concurrency = min(concurrency, len(clients))
+	var errsMu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan *Client)
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				msg, err := encoder.message(c, channel)
+				if err == nil {
+					err = c.writeMessage(ctx, msg)
+				}
+				if err != nil {
+					errsMu.Lock()
+					errs = append(errs, ClientError{Client: c, error: err})
+					errsMu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, c := range clients {
+		next <- c
+	}
+	close(next)
+	wg.Wait()
+	return
+}
+
+// requestClients sends a request to each client on the specified channel and
+// collects the responses. Once the completion criteria in opts are met, all
+// pending requests are cancelled; their responses contain an error wrapping
+// context.Canceled. The returned slice has one entry per client that passed
+// opts.Filter.
+func requestClients(
+	ctx context.Context,
+	clients []*Client,
+	channel string,
+	opts RequestOptions,
+	arguments []any,
+) ([]ClientResponse, error) {
+	// Encode arguments once for each codec
+	encoder := newArgumentEncoder(arguments)
+	if opts.Filter != nil {
+		selected := clients[:0]
+		for _, c := range clients {
+			if opts.Filter(c) {
+				selected = append(selected, c)
+			}
+		}
+		clients = selected
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Send all requests concurrently
+	type result struct {
+		index    int
+		response ClientResponse
+	}
+	results := make(chan result, len(clients))
+	for i, c := range clients {
+		go func() {
+			encodedArgs, err := encoder.encode(c)
+			var data any
+			if err == nil {
+				data, err = c.sendEncodedRequest(ctx, channel, encodedArgs)
+			}
+			results <- result{i, ClientResponse{Client: c, Data: data, Error: err}}
+		}()
+	}
+
+	// Collect responses. Pending requests are cancelled once enough responses
+	// are received, so this loop always finishes promptly afterwards.
+	responses := make([]ClientResponse, len(clients))
+	responded, succeeded := 0, 0
+	for range clients {
+		r := <-results
+		responses[r.index] = r.response
+		responded++
+		if r.response.Error == nil {
+			succeeded++
+		}
+		if (opts.Responses > 0 && responded >= opts.Responses) ||
+			(opts.Quorum > 0 && succeeded >= opts.Quorum) ||
+			(opts.Quorum > 0 && succeeded+len(clients)-responded < opts.Quorum) {
+			cancel(errResponseNotNeeded)
+		}
+	}
+	if opts.Quorum > 0 && succeeded < opts.Quorum {
+		return responses, fmt.Errorf(
+			"%w: %d of %d successful responses",
+			ErrQuorumNotReached, succeeded, opts.Quorum,
+		)
+	}
+	return responses, nil
+}
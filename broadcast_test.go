@@ -0,0 +1,262 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// respond answers the next request written to conn with data.
+func respond(t *testing.T, conn *mockConn, data any) Message {
+	t.Helper()
+	req := conn.waitWritten(t, time.Second)
+	if req.RequestID == nil {
+		t.Fatalf("expected request, got %+v", req)
+	}
+	conn.send(Message{RequestID: req.RequestID, ResponseData: data})
+	return req
+}
+
+// TestServerRequest verifies that a request sent on a ServerChannel collects a
+// response from every client.
+func TestServerRequest(t *testing.T) {
+	server := NewServer()
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	client2 := acceptClient(t, server, conn2)
+	defer server.Close()
+
+	done := make(chan []ClientResponse, 1)
+	go func() {
+		done <- server.Of("io").Request(context.Background(), "name")
+	}()
+	if req := respond(t, conn1, "one"); req.Channel != "io" {
+		t.Fatalf("expected request on 'io' channel, got %+v", req)
+	}
+	respond(t, conn2, "two")
+
+	responses := <-done
+	got := map[*Client]any{}
+	for _, r := range responses {
+		if r.Error != nil {
+			t.Fatalf("unexpected error: %v", r.Error)
+		}
+		got[r.Client] = r.Data
+	}
+	if len(got) != 2 || got[client1] != "one" || got[client2] != "two" {
+		t.Fatalf("unexpected responses: %v", got)
+	}
+
+	responses = server.Request(context.Background(), "open")
+	if len(responses) != 1 || responses[0].Client != nil ||
+		responses[0].Error == nil {
+		t.Fatalf("expected error requesting reserved event, got %v", responses)
+	}
+}
+
+// TestServerRequestWithFilter verifies that only clients selected by the
+// filter receive the request.
+func TestServerRequestWithFilter(t *testing.T) {
+	server := NewServer()
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	acceptClient(t, server, conn2)
+	defer server.Close()
+
+	done := make(chan []ClientResponse, 1)
+	go func() {
+		responses, err := server.RequestWith(context.Background(),
+			RequestOptions{Filter: func(c *Client) bool { return c == client1 }},
+			"name",
+		)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- responses
+	}()
+	respond(t, conn1, "one")
+
+	responses := <-done
+	if len(responses) != 1 || responses[0].Client != client1 {
+		t.Fatalf("expected one response from client1, got %v", responses)
+	}
+	select {
+	case msg := <-conn2.writeCh:
+		t.Fatalf("unexpected request sent to filtered client: %+v", msg)
+	default:
+	}
+}
+
+// TestServerRequestWithQuorum verifies that a quorum request completes once
+// enough clients respond and cancels the remaining requests.
+func TestServerRequestWithQuorum(t *testing.T) {
+	server := NewServer()
+	conns := []*mockConn{newMockConn(), newMockConn(), newMockConn()}
+	for _, conn := range conns {
+		acceptClient(t, server, conn)
+	}
+	defer server.Close()
+
+	type result struct {
+		responses []ClientResponse
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		responses, err := server.RequestWith(context.Background(),
+			RequestOptions{Quorum: 2}, "vote",
+		)
+		done <- result{responses, err}
+	}()
+	// Wait for the third client to receive the request before responding,
+	// so that it is not skipped once the quorum is reached
+	req := conns[2].waitWritten(t, time.Second)
+	respond(t, conns[0], true)
+	respond(t, conns[1], true)
+
+	// The third client then receives a cancellation
+	cancelMsg := conns[2].waitWritten(t, time.Second)
+	if cancelMsg.CancelReason == nil ||
+		*cancelMsg.RequestID != *req.RequestID {
+		t.Fatalf("expected cancellation for request, got %+v", cancelMsg)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+	succeeded, cancelled := 0, 0
+	for _, resp := range r.responses {
+		if resp.Error == nil {
+			succeeded++
+		} else if errors.Is(resp.Error, context.Canceled) {
+			cancelled++
+		}
+	}
+	if succeeded != 2 || cancelled != 1 {
+		t.Fatalf("expected 2 successes and 1 cancellation, got %v",
+			r.responses)
+	}
+}
+
+// TestServerRequestWithTimeout verifies that a quorum request returns
+// ErrQuorumNotReached when the timeout expires first.
+func TestServerRequestWithTimeout(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	responses, err := server.RequestWith(context.Background(),
+		RequestOptions{Timeout: 20 * time.Millisecond, Quorum: 1}, "slow",
+	)
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("expected ErrQuorumNotReached, got %v", err)
+	}
+	if len(responses) != 1 ||
+		!errors.Is(responses[0].Error, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded response, got %v", responses)
+	}
+}
+
+// blockingConn is a mockConn whose writes block until the context is done.
+type blockingConn struct {
+	*mockConn
+}
+
+func (b blockingConn) WriteMessage(ctx context.Context, msg *Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// TestServerEmitSlowClient verifies that a slow client neither stalls the
+// broadcast to other clients nor blocks Accept.
+func TestServerEmitSlowClient(t *testing.T) {
+	server := NewServer()
+	slow := blockingConn{newMockConn()}
+	fast := newMockConn()
+	if err := server.Accept(slow); err != nil {
+		t.Fatal(err)
+	}
+	acceptClient(t, server, fast)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []ClientError, 1)
+	go func() {
+		done <- server.Emit(ctx, "news", "headline")
+	}()
+
+	// The fast client receives the event while the slow write is pending
+	if msg := fast.waitWritten(t, time.Second); msg.EventName() != "news" {
+		t.Fatalf("expected 'news' event, got %+v", msg)
+	}
+	// Accept is not blocked by the pending broadcast
+	accepted := make(chan error, 1)
+	go func() {
+		accepted <- server.Accept(newMockConn())
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept blocked by pending broadcast")
+	}
+
+	cancel()
+	errs := <-done
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("expected one cancellation error, got %v", errs)
+	}
+}
+
+// countingCodec is a JSON codec that counts the messages it encodes
+type countingCodec struct {
+	jsonCodec
+	marshaled *atomic.Int32
+}
+
+func (c countingCodec) MarshalMessage(msg *Message) ([]byte, error) {
+	c.marshaled.Add(1)
+	return c.jsonCodec.MarshalMessage(msg)
+}
+
+// TestServerEmitEncodesOnce verifies that an event sent to many clients is
+// encoded once, and that each client still receives it.
+func TestServerEmitEncodesOnce(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	codec := countingCodec{marshaled: new(atomic.Int32)}
+	var remotes []Conn
+	for range 3 {
+		local, remote := PipeWith(PipeOptions{Codec: codec})
+		if err := server.Accept(local); err != nil {
+			t.Fatal(err)
+		}
+		remotes = append(remotes, remote)
+	}
+
+	before := codec.marshaled.Load()
+	if errs := server.Emit(context.Background(), "news", "headline"); errs != nil {
+		t.Fatal(errs)
+	}
+	if n := codec.marshaled.Load() - before; n != 1 {
+		t.Fatalf("expected event to be encoded once, got %d", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, remote := range remotes {
+		var msg Message
+		if err := remote.ReadMessage(ctx, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.EventName() != "news" || len(msg.Arguments) != 2 ||
+			string(msg.Arguments[1]) != `"headline"` {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package wrapper
+
+import (
+	"context"
+)
+
+// Requester is a channel to which requests can be sent. It is implemented by
+// ClientChannel, and therefore by *Client as well. It is used by Call.
+type Requester interface {
+	Name() string
+	request(
+		ctx context.Context, arguments []any, progress func(any),
+	) messageResponse
+}
+
+// Call sends a request for the specified event to the client and decodes the
+// response into a T. Unlike ClientChannel.Request, which returns numbers as
+// float64 and objects as map[string]any when using JSON, the raw response is
+// decoded directly into T by the connection's Codec. For example:
+//
+//	user, err := wrapper.Call[User](ctx, c, "getUser", id)
+//
+// If T is json.RawMessage and the connection uses the JSON codec, the
+// undecoded response is returned. If the remote end responds with no data, the
+// zero value of T is returned.
+func Call[T any](
+	ctx context.Context, ch Requester, eventName string, arguments ...any,
+) (T, error) {
+	var result T
+	resp := ch.request(ctx, append([]any{eventName}, arguments...), nil)
+	if resp.Error != nil {
+		return result, resp.Error
+	}
+	codec := resp.Codec
+	if codec == nil {
+		codec = JSON
+	}
+	raw := resp.Raw
+	if raw == nil {
+		if resp.Data == nil {
+			return result, nil
+		}
+		// Raw response is unavailable; encode the response data
+		var err error
+		if raw, err = codec.Marshal(resp.Data); err != nil {
+			return result, err
+		}
+	}
+	err := codec.Unmarshal(raw, &result)
+	return result, err
+}
@@ -0,0 +1,91 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// respondJSON waits for a request written to conn and responds with a message
+// decoded from JSON, as a WebSocket adapter would.
+func respondJSON(t *testing.T, conn *mockConn, data string) {
+	t.Helper()
+	req := conn.waitWritten(t, time.Second)
+	if req.RequestID == nil {
+		t.Fatalf("expected request, got %+v", req)
+	}
+	var msg Message
+	err := json.Unmarshal([]byte(
+		`{"i":`+strconv.Itoa(*req.RequestID)+`,"d":`+data+`}`,
+	), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.send(msg)
+}
+
+// TestCall verifies that Call decodes responses directly into the requested
+// type.
+func TestCall(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	type user struct {
+		ID   int64
+		Name string
+	}
+	done := make(chan error, 1)
+	go func() {
+		u, err := Call[user](context.Background(), client, "getUser", 1)
+		if err == nil && (u.ID != 9007199254740993 || u.Name != "Ann") {
+			t.Errorf("unexpected user %+v", u)
+		}
+		done <- err
+	}()
+	respondJSON(t, conn, `{"ID":9007199254740993,"Name":"Ann"}`)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// json.RawMessage returns the undecoded response
+	go func() {
+		raw, err := Call[json.RawMessage](
+			context.Background(), client.Of("data"), "raw",
+		)
+		if err == nil && string(raw) != `[1, 2]` {
+			t.Errorf("unexpected raw response %s", raw)
+		}
+		done <- err
+	}()
+	respondJSON(t, conn, `[1, 2]`)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Responses that were not decoded from JSON are encoded first
+	go func() {
+		n, err := Call[int](context.Background(), client, "count")
+		if err == nil && n != 3 {
+			t.Errorf("expected 3, got %d", n)
+		}
+		done <- err
+	}()
+	respond(t, conn, 3.0)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Response cannot be decoded into T
+	go func() {
+		_, err := Call[int](context.Background(), client, "count")
+		done <- err
+	}()
+	respondJSON(t, conn, `"three"`)
+	if err := <-done; err == nil {
+		t.Fatal("expected decoding error")
+	}
+}
@@ -3,6 +3,7 @@ package wrapper
 import (
 	"context"
 	"fmt"
+	"iter"
 )
 
 // ClientChannel is a channel on which events can be sent and received. Events
@@ -48,7 +49,17 @@ type ClientChannel struct {
 //     element in the slice will be converted into a new slice and supplied as
 //     the argument
 //
-// Optionally, the handler can provide an additional parameter for the
+// By default, the number of arguments of the event must match the number of
+// handler parameters. If the last parameter is variadic, any number of
+// additional arguments is accepted. See Server.SetArgumentPolicy to allow
+// missing or extra arguments.
+//
+// If a request handler returns a channel (i.e. `<-chan T`) or an
+// iter.Seq2[T, error], the response is streamed: each item is sent to the
+// remote end as a separate chunk until the channel is closed, the iterator
+// ends, or an error occurs. See ClientChannel.RequestStream.
+//
+// Optionally, the handler can provide an additional first parameter for the
 // context.Context of the request. Call ClientFromContext(ctx) to return the
 // *Client object for the client that emitted the event.
 //
@@ -83,20 +94,7 @@ type ClientChannel struct {
 // If On is called multiple times for the same event name, the last handler
 // will be used. If handler is nil, the event handler is removed.
 func (c ClientChannel) On(eventName string, handler any) ClientChannel {
-	if c.client == nil {
-		return c // channel closed; do nothing
-	}
-	if err := checkHandler(c.name, eventName, handler); err != nil {
-		panic(err)
-	}
-	key := handlerName{Channel: c.name, Event: eventName}
-	c.client.handlersMu.Lock()
-	if handler == nil {
-		delete(c.client.handlers, key)
-	} else {
-		c.client.handlers[key] = handler
-	}
-	c.client.handlersMu.Unlock()
+	c.setHandler(eventName, handler, false)
 	return c
 }
 
@@ -104,21 +102,32 @@ func (c ClientChannel) On(eventName string, handler any) ClientChannel {
 // See ClientChannel.On for more information about how event handlers are
 // called.
 func (c ClientChannel) Once(eventName string, handler any) ClientChannel {
+	c.setHandler(eventName, handler, true)
+	return c
+}
+
+// setHandler validates handler and adds it to the channel. If handler is nil,
+// the event handler is removed.
+func (c ClientChannel) setHandler(eventName string, handler any, once bool) {
 	if c.client == nil {
-		return c // channel closed; do nothing
+		return // channel closed; do nothing
 	}
-	if err := checkHandler(c.name, eventName, handler); err != nil {
+	handler, err := prepareHandler(c.name, eventName, handler)
+	if err != nil {
 		panic(err)
 	}
 	key := handlerName{Channel: c.name, Event: eventName}
+	handlers := c.client.handlers
+	if once {
+		handlers = c.client.handlersOnce
+	}
 	c.client.handlersMu.Lock()
 	if handler == nil {
-		delete(c.client.handlersOnce, key)
+		delete(handlers, key)
 	} else {
-		c.client.handlersOnce[key] = handler
+		handlers[key] = handler
 	}
 	c.client.handlersMu.Unlock()
-	return c
 }
 
 // Close removes all event handlers for this channel.
@@ -148,6 +157,21 @@ func checkEventName(arguments []any) (string, error) {
 	return name, nil
 }
 
+// checkEmitArguments ensures the event name is valid and is not a reserved
+// event on the main channel.
+func checkEmitArguments(channel string, arguments []any) error {
+	eventName, err := checkEventName(arguments)
+	if err != nil {
+		return err
+	}
+	if channel == "" && IsReservedEvent(eventName) {
+		return fmt.Errorf(
+			"cannot emit reserved event '%s' on main channel", eventName,
+		)
+	}
+	return nil
+}
+
 // Emit sends an event to the client on the specified channel. The passed
 // context can be used to cancel writing the message to the client. The second
 // argument is the event name that tells the remote end which event handler to
@@ -157,15 +181,9 @@ func (c ClientChannel) Emit(ctx context.Context, arguments ...any) error {
 	if c.client == nil {
 		return ChannelClosedError{Channel: c.name}
 	}
-	eventName, err := checkEventName(arguments)
-	if err != nil {
+	if err := checkEmitArguments(c.name, arguments); err != nil {
 		return err
 	}
-	if c.name == "" && IsReservedEvent(eventName) {
-		return fmt.Errorf(
-			"cannot emit reserved event '%s' on main channel", eventName,
-		)
-	}
 	return c.client.sendEvent(ctx, c.name, arguments...)
 }
 
@@ -175,19 +193,87 @@ func (c ClientChannel) Emit(ctx context.Context, arguments ...any) error {
 func (c ClientChannel) Request(
 	ctx context.Context, arguments ...any,
 ) (response any, err error) {
+	resp := c.request(ctx, arguments, nil)
+	return resp.Data, resp.Error
+}
+
+// RequestWithProgress sends a request to the client and returns the response
+// like ClientChannel.Request. In addition, progress is called with the value
+// of each progress notification that the remote handler sends with
+// ReportProgress before the response arrives. progress is called on a separate
+// goroutine, one notification at a time and in the order they were received,
+// so a slow callback does not delay reading messages from the connection. If
+// notifications arrive faster than progress handles them, the oldest pending
+// notifications are discarded. RequestWithProgress returns after progress has
+// returned for the last notification.
+//
+// Progress notifications are an extension of the ws-wrapper protocol, so the
+// remote handler can only send them if this end announced CapabilityProgress
+// in its hello message (see Client.SetHandshake).
+func (c ClientChannel) RequestWithProgress(
+	ctx context.Context, progress func(any), arguments ...any,
+) (response any, err error) {
+	resp := c.request(ctx, arguments, progress)
+	return resp.Data, resp.Error
+}
+
+// RequestStream sends a request to the client and returns an iterator over
+// the chunks of the response. The remote handler streams its response by
+// returning a channel or an iter.Seq2 (see ClientChannel.On). The request is
+// sent when iteration begins. Iteration stops after the last chunk or when an
+// error is yielded. If ctx is cancelled or the caller stops iterating early, a
+// cancellation is sent to the remote end so that its handler can stop the
+// stream. For example:
+//
+//	for line, err := range c.RequestStream(ctx, "tail", "app.log") {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    fmt.Println(line)
+//	}
+//
+// Chunks are buffered until the caller receives them, so the caller may send
+// other requests on the same client while iterating. If the caller falls too
+// far behind, the request is cancelled and iteration ends with
+// ErrStreamBufferFull after the buffered chunks.
+func (c ClientChannel) RequestStream(
+	ctx context.Context, arguments ...any,
+) iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		if c.client == nil {
+			yield(nil, ChannelClosedError{Channel: c.name})
+			return
+		}
+		if err := checkEmitArguments(c.name, arguments); err != nil {
+			yield(nil, err)
+			return
+		}
+		encodedArgs, err := c.client.encodeArguments(arguments)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		c.client.requestStream(ctx, c.name, encodedArgs)(yield)
+	}
+}
+
+// request sends a request to the client and waits for the response. If
+// progress is not nil, it is called with each progress notification. If the
+// request fails, the returned messageResponse holds the error.
+func (c ClientChannel) request(
+	ctx context.Context, arguments []any, progress func(any),
+) messageResponse {
 	if c.client == nil {
-		return nil, ChannelClosedError{Channel: c.name}
+		return messageResponse{Error: ChannelClosedError{Channel: c.name}}
 	}
-	eventName, err := checkEventName(arguments)
-	if err != nil {
-		return nil, err
+	if err := checkEmitArguments(c.name, arguments); err != nil {
+		return messageResponse{Error: err}
 	}
-	if c.name == "" && IsReservedEvent(eventName) {
-		return nil, fmt.Errorf(
-			"cannot emit reserved event '%s' on main channel", eventName,
-		)
+	encodedArgs, err := c.client.encodeArguments(arguments)
+	if err != nil {
+		return messageResponse{Error: err}
 	}
-	return c.client.sendRequest(ctx, c.name, arguments...)
+	return c.client.doRequest(ctx, c.name, encodedArgs, progress)
 }
 
 // Name returns the name of the channel
@@ -207,20 +293,7 @@ type ServerChannel struct {
 // On adds an event handler for the specified event to the channel. See
 // ClientChannel.On for more information about how event handlers are called.
 func (c ServerChannel) On(eventName string, handler any) ServerChannel {
-	if c.server == nil {
-		return c // channel closed; do nothing
-	}
-	if err := checkHandler(c.name, eventName, handler); err != nil {
-		panic(err)
-	}
-	key := handlerName{Channel: c.name, Event: eventName}
-	c.server.handlersMu.Lock()
-	if handler == nil {
-		delete(c.server.handlers, key)
-	} else {
-		c.server.handlers[key] = handler
-	}
-	c.server.handlersMu.Unlock()
+	c.setHandler(eventName, handler, false)
 	return c
 }
 
@@ -228,21 +301,32 @@ func (c ServerChannel) On(eventName string, handler any) ServerChannel {
 // See ClientChannel.On for more information about how event handlers are
 // called.
 func (c ServerChannel) Once(eventName string, handler any) ServerChannel {
+	c.setHandler(eventName, handler, true)
+	return c
+}
+
+// setHandler validates handler and adds it to the channel. If handler is nil,
+// the event handler is removed.
+func (c ServerChannel) setHandler(eventName string, handler any, once bool) {
 	if c.server == nil {
-		return c // channel closed; do nothing
+		return // channel closed; do nothing
 	}
-	if err := checkHandler(c.name, eventName, handler); err != nil {
+	handler, err := prepareHandler(c.name, eventName, handler)
+	if err != nil {
 		panic(err)
 	}
 	key := handlerName{Channel: c.name, Event: eventName}
+	handlers := c.server.handlers
+	if once {
+		handlers = c.server.handlersOnce
+	}
 	c.server.handlersMu.Lock()
 	if handler == nil {
-		delete(c.server.handlersOnce, key)
+		delete(handlers, key)
 	} else {
-		c.server.handlersOnce[key] = handler
+		handlers[key] = handler
 	}
 	c.server.handlersMu.Unlock()
-	return c
 }
 
 // Close removes all event handlers for this channel.
@@ -267,6 +351,27 @@ func (c *ServerChannel) Close() error {
 // occurred when sending the message to a specific client.
 func (c ServerChannel) Emit(
 	ctx context.Context, arguments ...any,
+) (errs []ClientError) {
+	return c.emit(ctx, nil, arguments)
+}
+
+// Broadcast sends an event to all clients on the specified channel except the
+// client that emitted the event currently being handled and any clients in
+// exclude. The emitting client is read from ctx using ClientFromContext, so
+// pass the event handler's context to rebroadcast a message to everyone but
+// its sender. If ctx has no client, only the clients in exclude are skipped.
+//
+// Otherwise, Broadcast behaves like ServerChannel.Emit.
+func (c ServerChannel) Broadcast(
+	ctx context.Context, exclude []*Client, arguments ...any,
+) (errs []ClientError) {
+	return c.emit(ctx, excludeFilter(ctx, exclude), arguments)
+}
+
+// emit sends an event to all clients on the channel that are selected by
+// filter. If filter is nil, the event is sent to all clients.
+func (c ServerChannel) emit(
+	ctx context.Context, filter func(*Client) bool, arguments []any,
 ) (errs []ClientError) {
 	if c.server == nil {
 		errs = append(errs, ClientError{
@@ -275,30 +380,67 @@ func (c ServerChannel) Emit(
 		})
 		return
 	}
-	eventName, err := checkEventName(arguments)
-	if err != nil {
-		errs = append(errs, ClientError{Client: nil, error: err})
-		return
+	return emitEvent(
+		ctx, c.server.eventClients(), filter, c.name, arguments,
+		c.server.broadcastConcurrency(),
+	)
+}
+
+// excludeFilter returns a filter that selects all clients except those in
+// exclude and the client stored in ctx, if any.
+func excludeFilter(ctx context.Context, exclude []*Client) func(*Client) bool {
+	skip := make(map[*Client]struct{}, len(exclude)+1)
+	if sender := ClientFromContext(ctx); sender != nil {
+		skip[sender] = struct{}{}
 	}
-	if c.name == "" && IsReservedEvent(eventName) {
-		errs = append(errs, ClientError{Client: nil, error: fmt.Errorf(
-			"cannot emit reserved event '%s' on main channel", eventName,
-		)})
-		return
+	for _, client := range exclude {
+		skip[client] = struct{}{}
+	}
+	return func(client *Client) bool {
+		_, ok := skip[client]
+		return !ok
 	}
-	c.server.clientsMu.Lock()
-	defer c.server.clientsMu.Unlock()
+}
 
-	for client := range c.server.clients {
-		err := client.sendEvent(ctx, c.name, arguments...)
-		if err != nil {
-			errs = append(errs, ClientError{
-				Client: client,
-				error:  err,
-			})
-		}
+// Request sends a request to all clients on the specified channel and waits
+// for all of them to respond. The passed context can be used to cancel the
+// request. The second argument is the event name that tells the remote end
+// which request handler to call. Returns one ClientResponse per client; if the
+// request could not be sent at all, a single ClientResponse with a nil Client
+// holds the error.
+func (c ServerChannel) Request(
+	ctx context.Context, arguments ...any,
+) []ClientResponse {
+	responses, err := c.RequestWith(ctx, RequestOptions{}, arguments...)
+	if err != nil {
+		return []ClientResponse{{Client: nil, Error: err}}
+	}
+	return responses
+}
+
+// RequestWith sends a request to the clients selected by opts.Filter on the
+// specified channel and collects their responses. The passed context, along
+// with opts.Timeout, sets the overall deadline; clients that have not
+// responded by then are sent a cancellation. When opts.Responses or
+// opts.Quorum is set, the request completes as soon as enough clients have
+// responded and all pending requests are cancelled.
+//
+// The returned slice has one ClientResponse per selected client. Responses
+// that were cancelled contain an error wrapping context.Canceled or
+// context.DeadlineExceeded. An error is returned if the request could not be
+// sent or if opts.Quorum was not reached.
+func (c ServerChannel) RequestWith(
+	ctx context.Context, opts RequestOptions, arguments ...any,
+) ([]ClientResponse, error) {
+	if c.server == nil {
+		return nil, ChannelClosedError{Channel: c.name}
+	}
+	if err := checkEmitArguments(c.name, arguments); err != nil {
+		return nil, err
 	}
-	return
+	return requestClients(
+		ctx, c.server.clientList(), c.name, opts, arguments,
+	)
 }
 
 // Name returns the name of the channel
@@ -2,10 +2,12 @@ package wrapper
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 )
 
 // errRebound is the context cancellation cause set by Bind when it attaches a
@@ -19,17 +21,39 @@ type Client struct {
 	connReqMu         sync.Mutex      // protects Context, Conn, and request stuff
 	ctx               context.Context // cancelled when the connection is closed
 	ctxCancel         func(error)     // called when the connection is closed
+	draining          bool            // set by drain; no new handlers are called
 	conn              Conn            // WebSocket connection; set `nil` on close
-	requestID         int             // auto-incrementing request ID
+	msgCodec          Codec           // codec used by conn
+	sendQueue         *sendQueue      // outbound queue for conn; nil if disabled
+	sendQueueConf     *sendQueueConfig
+	binaryAttach      *bool            // nil uses server's; see SetBinaryAttachments
+	limitsConf        *Limits          // nil uses server's; see SetLimits
+	handshakeConf     *bool            // nil uses server's; see SetHandshake
+	compatVersion     *ProtocolVersion // nil uses server's; see SetCompatibility
+	peerVersion       *ProtocolVersion // announced or detected; nil if unknown
+	peerCaps          []Capability     // announced in the remote hello message
+	helloSent         bool             // hello message sent on conn
+	heartbeatConf     *heartbeatConfig // nil uses server's; see SetHeartbeat
+	rtt               time.Duration    // see RTT
+	session           *session         // session assigned by the server
+	sessionID         string           // session announced by the remote end
+	lastSeq           uint64           // sequence number of last event received
+	requestID         int              // auto-incrementing request ID
 	requestResponseCh map[int]chan messageResponse
+	requestStreams    map[int]*requestStream      // see RequestStream
+	requestProgress   map[int]*progressDispatcher // see RequestWithProgress
 	inboundCancelsMu  sync.Mutex
 	inboundCancels    map[int]func(error) // cancel funcs for inbound requests
+	handlersWG        sync.WaitGroup      // in-flight event handlers
 	handlersMu        sync.Mutex
 	handlers          map[handlerName]any
 	handlersOnce      map[handlerName]any
+	middleware        []Middleware    // protected by handlersMu
+	argPolicy         *ArgumentPolicy // protected by handlersMu; nil uses server's
 	dataMu            sync.Mutex
 	data              map[string]any
-	server            *Server // server associated with the Client
+	server            *Server             // server associated with the Client
+	rooms             map[string]struct{} // protected by server.roomsMu
 }
 
 // NewClient creates a new Client not associated with any Server. Register
@@ -56,6 +80,8 @@ func NewClient(conn Conn) *Client {
 		// ClientChannel is set below
 		// ctx, ctxCancel, and conn are assigned in Bind method
 		requestResponseCh: make(map[int]chan messageResponse),
+		requestStreams:    make(map[int]*requestStream),
+		requestProgress:   make(map[int]*progressDispatcher),
 		inboundCancels:    make(map[int]func(error)),
 		handlers:          make(map[handlerName]any),
 		handlersOnce:      make(map[handlerName]any),
@@ -102,6 +128,15 @@ func (c *Client) Bind(conn Conn) {
 	c.connReqMu.Lock()
 	oldConn := c.conn
 	c.conn = conn
+	c.msgCodec = JSON
+	if cc, ok := conn.(CodecConn); ok {
+		c.msgCodec = cc.Codec()
+	}
+	c.sendQueue = nil // created after "open" handlers fire
+	c.peerVersion = nil
+	c.peerCaps = nil
+	c.helloSent = false
+	c.rtt = 0
 	// Cancel the old context, so the old readMessages goroutine exits silently.
 	if c.ctxCancel != nil {
 		c.ctxCancel(errRebound)
@@ -110,10 +145,12 @@ func (c *Client) Bind(conn Conn) {
 	// Abort any pending outbound requests; their responses will never arrive
 	// on the new connection.
 	for _, respCh := range c.requestResponseCh {
-		respCh <- messageResponse{nil, errRebound}
+		respCh <- messageResponse{Error: errRebound}
 		close(respCh)
 	}
 	clear(c.requestResponseCh)
+	clear(c.requestStreams) // callers see the context cancellation
+	clear(c.requestProgress)
 
 	// Create a context that is cancelled when the connection is closed.
 	// I know it is generally frowned upon to store the Context in a struct, but
@@ -124,11 +161,20 @@ func (c *Client) Bind(conn Conn) {
 	// a bit wasteful.
 	c.ctx, c.ctxCancel = context.WithCancelCause(context.Background())
 	c.ctx = context.WithValue(c.ctx, ClientKey, c)
+	ctx := c.ctx
 	c.connReqMu.Unlock()
 
 	if oldConn != nil {
 		_ = oldConn.Close(StatusGoingAway, errRebound.Error())
 	}
+	setReadLimit(conn, c.limits())
+	if err := c.bindSession(ctx); err != nil {
+		err = fmt.Errorf("session: %w", err)
+		c.emitError(err)
+		if c.server != nil {
+			c.server.emitError(c, err)
+		}
+	}
 
 	// Fire "open" handlers synchronously before launching readMessages so that
 	// any handlers registered inside the "open" callback are in place before
@@ -137,25 +183,102 @@ func (c *Client) Bind(conn Conn) {
 	if c.server != nil {
 		c.server.emitOpen(c)
 	}
+	if c.handshake() {
+		_ = c.sendHello(ctx) // a write error also fails readMessages
+	}
+	c.startSendQueue(conn)
+	c.startHeartbeat(conn)
 	go c.readMessages()
 }
 
+// SetSendQueue enables a bounded outbound queue of the given size for this
+// Client, overriding the setting of its Server (see Server.SetSendQueue). If
+// size is zero, messages are written synchronously. The setting takes effect
+// when a connection is bound; for clients accepted by a Server, call
+// SetSendQueue from the server's "open" handler. Messages sent from "open"
+// handlers are always written synchronously.
+func (c *Client) SetSendQueue(size int, policy OverflowPolicy) {
+	c.connReqMu.Lock()
+	c.sendQueueConf = &sendQueueConfig{size: max(size, 0), policy: policy}
+	c.connReqMu.Unlock()
+}
+
+// startSendQueue starts the send queue for conn, if enabled
+func (c *Client) startSendQueue(conn Conn) {
+	var config sendQueueConfig
+	if c.server != nil {
+		config = c.server.sendQueueConfig()
+	}
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	if c.conn != conn {
+		return // connection was closed or replaced
+	}
+	if c.sendQueueConf != nil {
+		config = *c.sendQueueConf
+	}
+	if config.size == 0 {
+		return
+	}
+	c.sendQueue = newSendQueue(c.ctx, conn, config,
+		func(err error) {
+			// Emit error and close connection
+			err = fmt.Errorf("write message: %w", err)
+			c.emitError(err)
+			if c.server != nil {
+				c.server.emitError(c, err)
+			}
+			c.closeConn(conn, StatusInternalError, err.Error())
+		},
+		func() {
+			c.emitError(ErrSendQueueFull)
+			if c.server != nil {
+				c.server.emitError(c, ErrSendQueueFull)
+			}
+			c.closeConn(conn, StatusPolicyViolation, ErrSendQueueFull.Error())
+		},
+	)
+}
+
+// closeConn closes the Client if conn is still its active connection
+func (c *Client) closeConn(conn Conn, status StatusCode, reason string) {
+	c.connReqMu.Lock()
+	active := c.conn == conn
+	c.connReqMu.Unlock()
+	if active {
+		c.close(status, reason, false, false, false)
+	}
+}
+
+// flushSendQueue waits until all messages in the send queue have been
+// written or the connection is closed
+func (c *Client) flushSendQueue() {
+	c.connReqMu.Lock()
+	q := c.sendQueue
+	c.connReqMu.Unlock()
+	if q != nil {
+		q.flush()
+	}
+}
+
 // Close closes the active connection, aborts pending requets, and fires the
 // "close"/"disconnect" event handlers synchronously before returning. If this
 // Client is associated with a Server, Close removes it from the Server's set of
 // connected clients.
 func (c *Client) Close(status StatusCode, reason string) error {
-	return c.close(status, reason, true, false)
+	return c.close(status, reason, true, false, false)
 }
 
 // close closes the active connection. Internal calls to close the Client should
 // use this method only. The public Close method is reserved for user-facing
-// code.
+// code. If now is true, the connection is closed with Conn.CloseNow instead of
+// performing the close handshake.
 func (c *Client) close(
 	status StatusCode,
 	reason string,
 	userClosed bool,
 	serverClosing bool,
+	now bool,
 ) error {
 	// Note: Server.Close sets userClosed to false
 	if !serverClosing && c.server != nil {
@@ -163,6 +286,12 @@ func (c *Client) close(
 		delete(c.server.clients, c)
 		c.server.clientsMu.Unlock()
 	}
+	// Keep the session, so it can be resumed, and leave all rooms; calls the
+	// Server's OnRoomLeave hook
+	if c.server != nil {
+		c.server.detachSession(c, userClosed || serverClosing)
+		c.server.leaveRooms(c)
+	}
 
 	// Get active connection
 	c.connReqMu.Lock()
@@ -175,21 +304,123 @@ func (c *Client) close(
 	// Cancel context and clear c.conn to indicate connection is closed
 	c.ctxCancel(fmt.Errorf("client closed (status: %v)", status))
 	c.conn = nil
+	c.sendQueue = nil
 	// Abort all pending outbound requests for this client.
 	for _, respCh := range c.requestResponseCh {
-		respCh <- messageResponse{nil, fmt.Errorf("connection closed")}
+		respCh <- messageResponse{Error: fmt.Errorf("connection closed")}
 		close(respCh)
 	}
 	clear(c.requestResponseCh)
+	clear(c.requestStreams) // callers see the context cancellation
+	clear(c.requestProgress)
 	c.connReqMu.Unlock()
 	// Emit "close" events and close the connection
 	c.emitClose(status, reason, userClosed)
 	if c.server != nil {
 		c.server.emitClose(c, status, reason, userClosed)
 	}
+	if now {
+		return conn.CloseNow()
+	}
 	return conn.Close(status, reason)
 }
 
+// errShuttingDown is sent to the remote end in response to requests received
+// while the Server is shutting down
+var errShuttingDown = errors.New("server is shutting down")
+
+// drain stops calling event handlers for inbound events and requests; requests
+// are rejected with errShuttingDown instead. Inbound messages are still read,
+// so responses to outbound requests, cancellations, and pongs are received
+// while in-flight handlers finish. After drain returns, handlersWG is not
+// incremented again.
+func (c *Client) drain() {
+	c.connReqMu.Lock()
+	c.draining = true
+	c.connReqMu.Unlock()
+}
+
+// startHandler adds an in-flight event handler to handlersWG. Returns false if
+// the Client is draining, in which case the handler must not be called.
+func (c *Client) startHandler() bool {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.handlersWG.Add(1)
+	return true
+}
+
+// SetBinaryAttachments sets whether []byte arguments are sent to this Client
+// as binary attachments, overriding the setting of its Server (see
+// Server.SetBinaryAttachments).
+func (c *Client) SetBinaryAttachments(enabled bool) {
+	c.connReqMu.Lock()
+	c.binaryAttach = &enabled
+	c.connReqMu.Unlock()
+}
+
+// binaryAttachments returns true if []byte arguments are sent to the client as
+// binary attachments
+func (c *Client) binaryAttachments() bool {
+	c.connReqMu.Lock()
+	codec, enabled := c.msgCodec, c.binaryAttach
+	c.connReqMu.Unlock()
+	if codec != nil && codec.Binary() {
+		return false // binary codecs encode []byte natively
+	}
+	if enabled != nil {
+		return *enabled
+	}
+	return c.server != nil && c.server.binaryAttachments()
+}
+
+// SetLimits sets the limits on inbound messages for this Client, overriding
+// the limits of its Server (see Server.SetLimits and Limits). The read limit of
+// the active connection is updated immediately.
+func (c *Client) SetLimits(limits Limits) {
+	c.connReqMu.Lock()
+	c.limitsConf = &limits
+	conn := c.conn
+	c.connReqMu.Unlock()
+	if conn != nil {
+		setReadLimit(conn, limits)
+	}
+}
+
+// limits returns the limits on inbound messages for this Client
+func (c *Client) limits() Limits {
+	c.connReqMu.Lock()
+	limits := c.limitsConf
+	c.connReqMu.Unlock()
+	if limits != nil {
+		return *limits
+	} else if c.server != nil {
+		return c.server.getLimits()
+	}
+	return Limits{}
+}
+
+// SetArgumentPolicy sets how event handlers for this Client are called when
+// the number of arguments received differs from the number of handler
+// parameters. It overrides the policy set by Server.SetArgumentPolicy.
+func (c *Client) SetArgumentPolicy(policy ArgumentPolicy) {
+	c.handlersMu.Lock()
+	c.argPolicy = &policy
+	c.handlersMu.Unlock()
+}
+
+// Use adds middleware that wraps the execution of every event handler called
+// for this Client, including handlers registered on its Server. Middleware
+// added to the Server runs before middleware added to the Client. Middleware
+// runs in the order it was added.
+func (c *Client) Use(middleware ...Middleware) {
+	c.handlersMu.Lock()
+	c.middleware = append(c.middleware, middleware...)
+	c.handlersMu.Unlock()
+}
+
 // Get returns the data for the client at the specified key
 func (c *Client) Get(key string) any {
 	c.dataMu.Lock()
@@ -212,6 +443,31 @@ func (c *Client) Of(name string) ClientChannel {
 	}
 }
 
+// errorObject returns the JavaScript error object sent to the remote end for
+// err. See WrapperError and Server.SetGenericErrorMessage.
+func (c *Client) errorObject(err error) map[string]any {
+	var wrapperErr WrapperError
+	if errors.As(err, &wrapperErr) {
+		obj := maps.Clone(wrapperErr.WrapperError())
+		if obj == nil {
+			obj = make(map[string]any, 1)
+		}
+		if _, ok := obj["message"]; !ok {
+			obj["message"] = wrapperErr.Error()
+		}
+		return obj
+	}
+	message := err.Error()
+	if c.server != nil {
+		c.server.handlersMu.Lock()
+		if c.server.genericErrMsg != "" {
+			message = c.server.genericErrMsg
+		}
+		c.server.handlersMu.Unlock()
+	}
+	return map[string]any{"message": message}
+}
+
 // sendReject sends a reject / error response to a request
 func (c *Client) sendReject(ctx context.Context, requestID *int, err error) error {
 	if requestID == nil {
@@ -219,20 +475,16 @@ func (c *Client) sendReject(ctx context.Context, requestID *int, err error) erro
 	} else if err == nil {
 		return fmt.Errorf("error is required")
 	}
-	c.connReqMu.Lock()
-	conn := c.conn
-	c.connReqMu.Unlock()
-	if conn == nil {
-		return nil // ignore message if connection is closed
-	}
-	return conn.WriteMessage(ctx, &Message{
+	writeErr := c.writeMessage(ctx, &Message{
 		RequestID: requestID,
 		// Write as JS error
 		ResponseJSError: true,
-		ResponseError: map[string]any{
-			"message": err.Error(),
-		},
+		ResponseError:   c.errorObject(err),
 	})
+	if writeErr == errConnectionClosed {
+		return nil // ignore message if connection is closed
+	}
+	return writeErr
 }
 
 // sendCancel sends a cancellation signal for a request
@@ -249,14 +501,20 @@ func (c *Client) sendCancel(
 	}
 	c.connReqMu.Lock()
 	_, ok := c.requestResponseCh[*requestID]
+	if !ok {
+		_, ok = c.requestStreams[*requestID]
+	}
 	delete(c.requestResponseCh, *requestID)
-	conn := c.conn
+	delete(c.requestStreams, *requestID)
+	delete(c.requestProgress, *requestID)
 	c.connReqMu.Unlock()
-	if !ok || conn == nil {
-		// request complete or connection closed
-		return nil
+	if !ok {
+		return nil // request complete
+	}
+	if !c.ProtocolVersion().AtLeast(ProtocolV4) {
+		return nil // remote end does not support cancellation
 	}
-	return conn.WriteMessage(ctx, &Message{
+	err := c.writeMessage(ctx, &Message{
 		RequestID: requestID,
 		// Write as JS error
 		ResponseJSError: true,
@@ -264,6 +522,10 @@ func (c *Client) sendCancel(
 			"message": reason.Error(),
 		},
 	})
+	if err == errConnectionClosed {
+		return nil // ignore message if connection is closed
+	}
+	return err
 }
 
 // sendResolve sends a resolve / data response to a request
@@ -271,52 +533,109 @@ func (c *Client) sendResolve(ctx context.Context, requestID *int, data any) erro
 	if requestID == nil {
 		return fmt.Errorf("requestID is required")
 	}
-	c.connReqMu.Lock()
-	conn := c.conn
-	c.connReqMu.Unlock()
-	if conn == nil {
-		return nil // ignore message if connection is closed
-	}
-	return conn.WriteMessage(ctx, &Message{
+	err := c.writeMessage(ctx, &Message{
 		RequestID:    requestID,
 		ResponseData: data,
 	})
+	if err == errConnectionClosed {
+		return nil // ignore message if connection is closed
+	}
+	return err
 }
 
-// sendEvent sends an event to the client
-func (c *Client) sendEvent(ctx context.Context, channel string, arguments ...any) error {
+// errConnectionClosed is returned when writing to a closed connection
+var errConnectionClosed = errors.New("connection is closed")
+
+// writeMessage writes msg to the active connection, or adds it to the send
+// queue if one is enabled. If the Client has a session, events are numbered
+// and buffered, so they can be replayed when the session is resumed (see
+// Server.SetSessions). Returns errConnectionClosed if the connection is
+// closed.
+func (c *Client) writeMessage(ctx context.Context, msg *Message) error {
+	c.connReqMu.Lock()
+	sess := c.session
+	c.connReqMu.Unlock()
+	if sess != nil && msg.RequestID == nil && msg.Arguments != nil {
+		return sess.send(ctx, c.server, c, msg)
+	}
+	return c.write(ctx, msg)
+}
+
+// write writes msg to the active connection, or adds it to the send queue if
+// one is enabled. Returns errConnectionClosed if the connection is closed.
+func (c *Client) write(ctx context.Context, msg *Message) error {
 	c.connReqMu.Lock()
 	conn := c.conn
+	q := c.sendQueue
 	c.connReqMu.Unlock()
 	if conn == nil {
-		return fmt.Errorf("connection is closed")
-	}
-	// Encode arguments as JSON
-	jsonArgs := make([]json.RawMessage, len(arguments))
-	for i, arg := range arguments {
-		buf, err := json.Marshal(arg)
-		if err != nil {
-			return err
-		}
-		jsonArgs[i] = buf
+		return errConnectionClosed
+	}
+	if q != nil {
+		return q.enqueue(ctx, msg)
+	}
+	return conn.WriteMessage(ctx, msg)
+}
+
+// Codec returns the codec used to encode messages on the client's connection.
+// If the connection does not implement CodecConn, JSON is returned.
+func (c *Client) Codec() Codec {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	if c.msgCodec == nil {
+		return JSON
+	}
+	return c.msgCodec
+}
+
+// sendEvent sends an event to the client
+func (c *Client) sendEvent(ctx context.Context, channel string, arguments ...any) error {
+	encodedArgs, err := c.encodeArguments(arguments)
+	if err != nil {
+		return err
 	}
 	// Send event to client
-	return conn.WriteMessage(ctx, &Message{
-		Channel:   channel,
-		Arguments: jsonArgs,
+	return c.writeMessage(ctx, &Message{
+		Channel:     channel,
+		Arguments:   encodedArgs.values,
+		Attachments: encodedArgs.attachments,
 	})
 }
 
-// sendRequest sends a request to the client and returns the response
-func (c *Client) sendRequest(
-	ctx context.Context, channel string, arguments ...any,
+// encodeArguments encodes arguments to be sent to the client
+func (c *Client) encodeArguments(arguments []any) (encodedArguments, error) {
+	return encodeArguments(c.Codec(), arguments, c.binaryAttachments())
+}
+
+// sendEncodedRequest sends a request with encoded arguments to the client and
+// returns the response
+func (c *Client) sendEncodedRequest(
+	ctx context.Context, channel string, arguments encodedArguments,
 ) (any, error) {
+	resp := c.doRequest(ctx, channel, arguments, nil)
+	return resp.Data, resp.Error
+}
+
+// doRequest sends a request with encoded arguments to the client and waits for
+// the response. If progress is not nil, it is called with each
+// progress notification received for the request. If the request fails, the
+// returned messageResponse holds the error.
+func (c *Client) doRequest(
+	ctx context.Context, channel string, arguments encodedArguments,
+	progress func(any),
+) messageResponse {
+	// Progress notifications are passed to progress by a dispatcher, which
+	// has passed all of them before doRequest returns
+	var dispatcher *progressDispatcher
+	if progress != nil {
+		dispatcher = newProgressDispatcher(progress)
+		defer dispatcher.close()
+	}
 	c.connReqMu.Lock()
-	conn := c.conn
 	ctxClient := c.ctx
-	if conn == nil {
+	if c.conn == nil {
 		c.connReqMu.Unlock()
-		return nil, fmt.Errorf("connection is closed")
+		return messageResponse{Error: errConnectionClosed}
 	}
 	// Create channel for message response
 	respCh := make(chan messageResponse, 1)
@@ -326,57 +645,60 @@ func (c *Client) sendRequest(
 	if c.requestResponseCh[requestID] != nil {
 		// should never happen
 		c.connReqMu.Unlock()
-		return nil, fmt.Errorf("request ID %d already in use", requestID)
+		return messageResponse{
+			Error: fmt.Errorf("request ID %d already in use", requestID),
+		}
 	} else {
 		c.requestResponseCh[requestID] = respCh
-		c.connReqMu.Unlock()
-	}
-
-	// Encode arguments as JSON
-	jsonArgs := make([]json.RawMessage, len(arguments))
-	for i, arg := range arguments {
-		buf, err := json.Marshal(arg)
-		if err != nil {
-			return nil, err
+		if dispatcher != nil {
+			c.requestProgress[requestID] = dispatcher
 		}
-		jsonArgs[i] = buf
+		c.connReqMu.Unlock()
 	}
 
 	// Send request to client
-	err := conn.WriteMessage(ctx, &Message{
-		Channel:   channel,
-		Arguments: jsonArgs,
-		RequestID: &requestID,
+	err := c.writeMessage(ctx, &Message{
+		Channel:     channel,
+		Arguments:   arguments.values,
+		Attachments: arguments.attachments,
+		RequestID:   &requestID,
 	})
 	if err != nil {
 		c.connReqMu.Lock()
 		delete(c.requestResponseCh, requestID)
+		delete(c.requestProgress, requestID)
 		c.connReqMu.Unlock()
-		return nil, fmt.Errorf("sending request: %w", err)
+		return messageResponse{Error: fmt.Errorf("sending request: %w", err)}
 	}
 
 	// Wait for response
 	select {
 	case resp, ok := <-respCh:
 		if !ok {
-			return nil, fmt.Errorf("response channel closed unexpectedly")
+			return messageResponse{
+				Error: fmt.Errorf("response channel closed unexpectedly"),
+			}
 		}
-		return resp.Data, resp.Error
+		return resp
 	case <-ctxClient.Done():
-		return nil, fmt.Errorf("awaiting response: %w", context.Cause(ctxClient))
+		return messageResponse{
+			Error: fmt.Errorf("awaiting response: %w", context.Cause(ctxClient)),
+		}
 	case <-ctx.Done():
 		cancelCause := context.Cause(ctx)
 		_ = c.sendCancel(ctxClient, &requestID, cancelCause)
-		return nil, fmt.Errorf("awaiting response: %w", cancelCause)
+		return messageResponse{
+			Error: fmt.Errorf("awaiting response: %w", cancelCause),
+		}
 	}
 }
 
-// readMessages reads messages from the client connection and handles them.
-// Cancel the context to stop reading messages
+// readMessages reads messages from the client connection and handles them
 func (c *Client) readMessages() {
 	// Read messages from the client connection
 	c.connReqMu.Lock()
 	conn := c.conn
+	codec := c.msgCodec
 	ctx := c.ctx
 	c.connReqMu.Unlock()
 	if conn == nil {
@@ -387,28 +709,77 @@ func (c *Client) readMessages() {
 		var msg Message
 		err := conn.ReadMessage(ctx, &msg)
 		// If the context is cancelled
+		var closeErr CloseError
 		if ctx.Err() != nil {
 			// Connection was lost; exit silently
 			return
+		} else if errors.As(err, &closeErr) {
+			// Remote end closed the connection
+			c.close(closeErr.Code, closeErr.Reason, false, false, false)
+			return
 		} else if err != nil {
 			// Emit error and close connection
+			status := StatusInternalError
+			if errors.Is(err, ErrMessageTooBig) {
+				status = StatusMessageTooBig // exceeds the read limit
+			}
 			err = fmt.Errorf("read message: %w", err)
 			c.emitError(err)
 			if c.server != nil {
 				c.server.emitError(c, err)
 			}
-			c.close(StatusInternalError, err.Error(), false, false)
+			c.close(status, err.Error(), false, false, false)
+			return
+		}
+
+		// Close the connection if the message is too big
+		if err := c.limits().checkSize(msg); err != nil {
+			err = fmt.Errorf("read message: %w", err)
+			c.emitError(err)
+			if c.server != nil {
+				c.server.emitError(c, err)
+			}
+			c.close(StatusMessageTooBig, err.Error(), false, false, false)
 			return
 		}
 
 		// Emit only valid messages
+		msg.codec = codec
 		msg.processed = make(chan struct{})
 		c.emitMessage(msg)
 
+		if msg.Version != "" {
+			// Hello message announcing the protocol version of the remote end
+			close(msg.processed)
+			if err := c.handleHello(ctx, msg.Version, msg.Capabilities); err != nil {
+				err = fmt.Errorf("handshake: %w", err)
+				c.emitError(err)
+				if c.server != nil {
+					c.server.emitError(c, err)
+				}
+			}
+			continue
+		}
+		if msg.Session != "" {
+			// Session announcement or request to resume a session
+			close(msg.processed)
+			if err := c.handleSession(ctx, msg.Session, msg.Sequence); err != nil {
+				err = fmt.Errorf("session: %w", err)
+				c.emitError(err)
+				if c.server != nil {
+					c.server.emitError(c, err)
+				}
+			}
+			continue
+		}
 		if msg.IgnoreIfFalse != nil && !*msg.IgnoreIfFalse {
 			close(msg.processed)
 			continue // ignore message
 		}
+		if msg.Sequence != 0 && !c.receivedEvent(msg.Sequence) {
+			close(msg.processed)
+			continue // event was replayed, but already received
+		}
 
 		// Note: handleMessage will close `msg.processed`
 		err = c.handleMessage(ctx, msg)
@@ -422,7 +793,7 @@ func (c *Client) readMessages() {
 			if c.server != nil {
 				c.server.emitError(c, err)
 			}
-			c.close(StatusInternalError, err.Error(), false, false)
+			c.close(StatusInternalError, err.Error(), false, false, false)
 			return
 		}
 	}
@@ -494,6 +865,48 @@ func (c *Client) emitClose(s StatusCode, reason string, userClosed bool) bool {
 	)
 }
 
+// lookupHandler returns the event handler for the given channel and event
+// name. Handlers added to the Client take precedence over handlers added to its
+// Server. One-time handlers are removed. Returns nil if there is no handler or
+// the event is reserved.
+func (c *Client) lookupHandler(channel, eventName string) any {
+	if channel == "" && IsReservedEvent(eventName) {
+		return nil
+	}
+	handlerID := handlerName{Channel: channel, Event: eventName}
+	c.handlersMu.Lock()
+	handler, ok := c.handlersOnce[handlerID]
+	if ok {
+		delete(c.handlersOnce, handlerID)
+	} else {
+		handler = c.handlers[handlerID]
+	}
+	c.handlersMu.Unlock()
+	if handler != nil || c.server == nil {
+		return handler
+	}
+	c.server.handlersMu.Lock()
+	defer c.server.handlersMu.Unlock()
+	handler, ok = c.server.handlersOnce[handlerID]
+	if ok {
+		delete(c.server.handlersOnce, handlerID)
+	} else {
+		handler = c.server.handlers[handlerID]
+	}
+	return handler
+}
+
+// noHandlerError returns the error sent in response to a request for which no
+// event handler exists
+func noHandlerError(channel, eventName string) error {
+	if channel == "" {
+		return fmt.Errorf("no event listener for '%s'", eventName)
+	}
+	return fmt.Errorf(
+		"no event listener for '%s' on channel '%s'", eventName, channel,
+	)
+}
+
 // handleMessage processes an inbound message for this client. Returns an error
 // if there was an error sending the response to the client.
 func (c *Client) handleMessage(ctx context.Context, msg Message) error {
@@ -501,53 +914,63 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 	var cancel context.CancelCauseFunc
 	// Get message event name if any
 	eventName := msg.EventName()
+	if msg.Channel == "" && eventName == pingEvent && msg.RequestID != nil {
+		// Answer protocol-level heartbeat ping
+		defer close(msg.processed)
+		return c.sendResolve(ctx, msg.RequestID, nil)
+	}
 	if eventName != "" {
-		// Process inbound event/request
-		handlerID := handlerName{Channel: msg.Channel, Event: eventName}
+		// Enforce limits before looking for a handler
+		if err := c.limits().checkEvent(msg, eventName); err != nil {
+			defer close(msg.processed)
+			if msg.RequestID != nil {
+				return c.sendReject(ctx, msg.RequestID, err)
+			}
+			c.emitError(err)
+			if c.server != nil {
+				c.server.emitError(c, err)
+			}
+			c.close(StatusPolicyViolation, err.Error(), false, false, false)
+			return nil
+		}
+		// Reject new events and requests while the Server is shutting down
+		if !c.startHandler() {
+			defer close(msg.processed)
+			if msg.RequestID != nil {
+				return c.sendReject(ctx, msg.RequestID, errShuttingDown)
+			}
+			return nil
+		}
+
+		// Look up the handler now, so one-time handlers are called in the
+		// order messages are received. Middleware runs even if there is no
+		// handler, and the handler is looked up again if middleware changes
+		// the channel or event name.
+		handler := c.lookupHandler(msg.Channel, eventName)
 
-		// Get client-specific handler
+		// Get middleware and settings for the handler
 		c.handlersMu.Lock()
-		handler, ok := c.handlersOnce[handlerID]
-		if ok {
-			delete(c.handlersOnce, handlerID)
-		} else {
-			handler = c.handlers[handlerID]
+		middleware := c.middleware
+		var policy ArgumentPolicy
+		if c.argPolicy != nil {
+			policy = *c.argPolicy
 		}
+		clientPolicy := c.argPolicy != nil
 		c.handlersMu.Unlock()
 
-		// Get server's handler Context function
+		// Get server's handler Context function and middleware
 		var handlerCtxFunc HandlerContextFunc
 		if c.server != nil {
 			c.server.handlersMu.Lock()
 			handlerCtxFunc = c.server.handlerCtxFunc
-			// Get server handler if no client handler exists
-			if handler == nil {
-				handler, ok = c.server.handlersOnce[handlerID]
-				if ok {
-					delete(c.server.handlersOnce, handlerID)
-				} else {
-					handler = c.server.handlers[handlerID]
-				}
+			if !clientPolicy {
+				policy = c.server.argPolicy
 			}
-			c.server.handlersMu.Unlock()
-		}
-
-		// Handle missing handler
-		if handler == nil {
-			defer close(msg.processed)
-			err := fmt.Errorf(
-				"no event listener for '%s' on channel '%s'",
-				eventName, msg.Channel,
+			// Server middleware runs before client middleware
+			middleware = append(
+				slices.Clip(c.server.middleware), middleware...,
 			)
-			if msg.Channel == "" {
-				err = fmt.Errorf("no event listener for '%s'", eventName)
-			}
-			// Send error response if it's a request
-			if msg.RequestID != nil {
-				return c.sendReject(ctx, msg.RequestID, err)
-			}
-			// Otherwise, ignore this message
-			return nil
+			c.server.handlersMu.Unlock()
 		}
 
 		// Wrap context for handler execution
@@ -558,6 +981,9 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 		// For inbound requests, create a request-specific cancellable context
 		// to allow a protocol-level cancellation message to cancel the handler.
 		if msg.RequestID != nil {
+			handlerCtx = context.WithValue(
+				handlerCtx, requestIDKey, msg.RequestID,
+			)
 			handlerCtx, cancel = context.WithCancelCause(handlerCtx)
 			// Save the CancelCauseFunc for the request
 			c.inboundCancelsMu.Lock()
@@ -565,34 +991,56 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 			c.inboundCancelsMu.Unlock()
 		}
 
-		// Call handler with arguments
+		// Call middleware and handler with arguments
 		go func() {
+			defer c.handlersWG.Done()
 			defer close(msg.processed)
-			result, err := callHandler(
-				handlerCtx, handler, msg.HandlerArguments(),
+			call := chainMiddleware(middleware,
+				func(ctx context.Context, call HandlerCall) (any, error) {
+					handler := handler
+					if call.Channel != msg.Channel || call.Event != eventName {
+						handler = c.lookupHandler(call.Channel, call.Event)
+					}
+					if handler == nil {
+						return nil, noHandlerError(call.Channel, call.Event)
+					}
+					codec := argumentCodec(msg.codec, call.Attachments)
+					return callHandler(
+						ctx, handler, codec, call.Arguments, policy,
+					)
+				},
 			)
-			// We are done running the handler, so cancel the handler context
-			if cancel != nil {
-				cancel(context.Canceled)
-			}
-
+			result, err := call(handlerCtx, HandlerCall{
+				Client:      c,
+				Channel:     msg.Channel,
+				Event:       eventName,
+				RequestID:   msg.RequestID,
+				Arguments:   msg.HandlerArguments(),
+				Attachments: msg.Attachments,
+			})
 			if msg.RequestID == nil {
 				// Silently ignore the response if it's not a request
 				return
 			}
 
-			// Clean up inbound cancellation
-			c.inboundCancelsMu.Lock()
-			delete(c.inboundCancels, *msg.RequestID)
-			c.inboundCancelsMu.Unlock()
-
 			if err != nil {
 				// Send error response
 				err = c.sendReject(ctx, msg.RequestID, err)
+			} else if stream, ok := resultStream(handlerCtx, result); ok {
+				// Send each item of the stream, then the final response
+				err = c.sendStream(ctx, handlerCtx, msg.RequestID, stream)
 			} else {
 				// Send data response
 				err = c.sendResolve(ctx, msg.RequestID, result)
 			}
+
+			// We are done running the handler, so cancel the handler context
+			// and clean up inbound cancellation
+			cancel(context.Canceled)
+			c.inboundCancelsMu.Lock()
+			delete(c.inboundCancels, *msg.RequestID)
+			c.inboundCancelsMu.Unlock()
+
 			if err != nil {
 				// Emit error and close client
 				err = fmt.Errorf("responding to request: %w", err)
@@ -600,7 +1048,7 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 				if c.server != nil {
 					c.server.emitError(c, err)
 				}
-				c.close(StatusInternalError, err.Error(), false, false)
+				c.close(StatusInternalError, err.Error(), false, false, false)
 			}
 		}()
 		return nil
@@ -614,6 +1062,7 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 
 	// Handle request cancellation message (ws-wrapper v4)
 	if msg.CancelReason != nil {
+		c.detectVersion(ProtocolV4)
 		c.inboundCancelsMu.Lock()
 		cancel, ok := c.inboundCancels[*msg.RequestID]
 		if ok {
@@ -626,20 +1075,45 @@ func (c *Client) handleMessage(ctx context.Context, msg Message) error {
 		return nil
 	}
 
+	// Handle progress notification
+	if msg.Progress != nil {
+		c.connReqMu.Lock()
+		dispatcher := c.requestProgress[*msg.RequestID]
+		c.connReqMu.Unlock()
+		if dispatcher != nil {
+			dispatcher.post(msg.Progress)
+		}
+		return nil
+	}
+
 	// Get request handler
 	c.connReqMu.Lock()
 	respCh, ok := c.requestResponseCh[*msg.RequestID]
 	if ok {
 		delete(c.requestResponseCh, *msg.RequestID)
+		delete(c.requestProgress, *msg.RequestID)
+	}
+	stream := c.requestStreams[*msg.RequestID]
+	if stream != nil && !msg.StreamChunk {
+		delete(c.requestStreams, *msg.RequestID) // final response
 	}
 	c.connReqMu.Unlock()
+	if stream != nil {
+		c.handleStreamResponse(ctx, stream, msg)
+		return nil
+	}
 	if respCh == nil {
 		return nil // ignore message with invalid request ID
 	}
 
 	// Process response
 	res, err := msg.Response()
-	respCh <- messageResponse{res, err}
+	respCh <- messageResponse{
+		Data:  res,
+		Raw:   msg.rawResponseData,
+		Codec: msg.codec,
+		Error: err,
+	}
 	close(respCh)
 
 	return nil
@@ -341,6 +341,66 @@ func TestHandlerReturnsError(t *testing.T) {
 	conn.Close(StatusNormalClosure, "done")
 }
 
+// codeError is an error with a code that implements WrapperError
+type codeError struct {
+	code string
+}
+
+func (e codeError) Error() string {
+	return "failed with code " + e.code
+}
+
+func (e codeError) WrapperError() map[string]any {
+	return map[string]any{"name": "CodeError", "code": e.code}
+}
+
+// TestHandlerReturnsWrapperError verifies that errors implementing
+// WrapperError are sent as JS error objects, even when wrapped, and that other
+// errors can be replaced with a generic message.
+func TestHandlerReturnsWrapperError(t *testing.T) {
+	server := NewServer()
+	server.SetGenericErrorMessage("internal error")
+	conn := newMockConn()
+
+	server.On("coded", func() error {
+		return fmt.Errorf("coded: %w", codeError{code: "E42"})
+	})
+	server.On("secret", func() error {
+		return fmt.Errorf("database password is hunter2")
+	})
+
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusNormalClosure, "done")
+
+	tests := []struct {
+		event    string
+		expected map[string]any
+	}{
+		{"coded", map[string]any{
+			"message": "failed with code E42",
+			"name":    "CodeError",
+			"code":    "E42",
+		}},
+		{"secret", map[string]any{"message": "internal error"}},
+	}
+	for i, test := range tests {
+		reqID := i + 1
+		conn.send(Message{
+			RequestID: &reqID,
+			Arguments: []json.RawMessage{[]byte(`"` + test.event + `"`)},
+		})
+		resp := conn.waitWritten(t, time.Second)
+		if !resp.ResponseJSError {
+			t.Fatal("expected error encoded as JS error")
+		}
+		if !reflect.DeepEqual(test.expected, resp.ResponseError) {
+			t.Fatalf("expected %v, got %v", test.expected, resp.ResponseError)
+		}
+	}
+}
+
 // TestEventFireAndForget verifies that events sent without a request ID
 // invoke the handler without sending any response.
 func TestEventFireAndForget(t *testing.T) {
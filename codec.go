@@ -0,0 +1,248 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Codec encodes and decodes ws-wrapper messages sent over a connection, as
+// well as the values they carry (event arguments and responses). JSON is used
+// by default; other codecs, such as the MessagePack codec in the
+// codecs/msgpack module, can make messages more compact and faster to encode.
+//
+// A connection chooses its codec by implementing CodecConn. The adapters in
+// the adapters subdirectory choose the codec registered for the WebSocket
+// subprotocol negotiated by the connection (see RegisterCodec).
+//
+// The event arguments in Message.Arguments (and HandlerCall.Arguments) are
+// encoded by the connection's codec, even though their type is
+// json.RawMessage. Use Client.Codec to decode them.
+type Codec interface {
+	// Name returns the name of the codec. It is also the WebSocket
+	// subprotocol used to negotiate the codec (i.e. "json").
+	Name() string
+	// Binary returns true if messages are sent as binary WebSocket frames
+	// rather than text frames.
+	Binary() bool
+	// Marshal encodes a value, such as an event argument.
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes a value encoded by Marshal into v.
+	Unmarshal(data []byte, v any) error
+	// MarshalMessage encodes msg as a WebSocket frame. The event arguments of
+	// msg are already encoded by Marshal.
+	MarshalMessage(msg *Message) ([]byte, error)
+	// UnmarshalMessage decodes a WebSocket frame into msg. The event
+	// arguments must be left encoded so that they can be decoded by
+	// Unmarshal into the types expected by event handlers. Likewise, the
+	// encoded response data should be passed to Message.SetRawResponseData.
+	UnmarshalMessage(data []byte, msg *Message) error
+}
+
+// CodecConn is a Conn that encodes messages with a specific Codec. If a Conn
+// does not implement CodecConn, JSON is used.
+type CodecConn interface {
+	Conn
+	// Codec returns the codec used to encode messages on the connection
+	Codec() Codec
+}
+
+// DepthCodec is a Codec that can check the nesting depth of encoded values,
+// so that Limits.MaxDepth can be enforced before event arguments are decoded.
+// JSON and the codecs in the codecs subdirectory implement it. The arguments
+// of messages encoded by codecs that do not implement DepthCodec are not
+// checked against Limits.MaxDepth.
+type DepthCodec interface {
+	Codec
+	// ExceedsDepth returns true if arrays and maps (or objects) in the
+	// encoded value data are nested more than maxDepth levels deep
+	ExceedsDepth(data []byte, maxDepth int) bool
+}
+
+// JSON is the default Codec. Messages are sent as JSON-encoded text frames, as
+// specified by the ws-wrapper protocol.
+var JSON Codec = jsonCodec{}
+
+// jsonCodec implements the JSON Codec
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) MarshalMessage(msg *Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) UnmarshalMessage(data []byte, msg *Message) error {
+	return json.Unmarshal(data, msg)
+}
+
+func (jsonCodec) ExceedsDepth(data []byte, maxDepth int) bool {
+	return exceedsDepth(data, maxDepth)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{JSON.Name(): JSON}
+)
+
+// RegisterCodec makes a codec available by name to CodecFor. Codecs are
+// usually registered by the init function of the package that implements
+// them. If a codec with the same name is already registered, it is replaced.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	codecs[codec.Name()] = codec
+	codecsMu.Unlock()
+}
+
+// CodecFor returns the registered codec with the given name, which is usually
+// the WebSocket subprotocol negotiated by a connection. If name is empty or no
+// such codec is registered, JSON is returned.
+func CodecFor(name string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if codec, ok := codecs[name]; ok {
+		return codec
+	}
+	return JSON
+}
+
+// Codecs returns the names of all registered codecs, which can be offered as
+// WebSocket subprotocols when accepting a connection.
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// encodedArguments are event arguments encoded for a connection
+type encodedArguments struct {
+	values      []json.RawMessage
+	attachments [][]byte // binary attachments referenced by values
+}
+
+// encodeArguments encodes each argument with codec. If attach is true, []byte
+// arguments are replaced by placeholders and sent as binary attachments.
+func encodeArguments(
+	codec Codec, arguments []any, attach bool,
+) (encodedArguments, error) {
+	var encoded encodedArguments
+	encoded.values = make([]json.RawMessage, len(arguments))
+	for i, arg := range arguments {
+		if b, ok := arg.([]byte); ok && attach {
+			encoded.values[i] = encodePlaceholder(len(encoded.attachments))
+			encoded.attachments = append(encoded.attachments, b)
+			continue
+		}
+		buf, err := codec.Marshal(arg)
+		if err != nil {
+			return encodedArguments{}, err
+		}
+		if attach {
+			buf = escapePlaceholder(buf)
+		}
+		encoded.values[i] = buf
+	}
+	return encoded, nil
+}
+
+// argumentEncoder encodes the arguments of a message sent to many clients once
+// for each codec used by the clients. For events, the whole message is encoded
+// once as well (see Message.Encode).
+type argumentEncoder struct {
+	arguments []any
+	mu        sync.Mutex
+	encoded   map[encoding]encodedArguments
+	errs      map[encoding]error
+	frames    map[encoding][]byte
+}
+
+// encoding identifies how arguments are encoded for a client
+type encoding struct {
+	codec  string // codec name
+	attach bool   // []byte arguments are sent as attachments
+}
+
+// newArgumentEncoder returns an argumentEncoder for arguments
+func newArgumentEncoder(arguments []any) *argumentEncoder {
+	return &argumentEncoder{
+		arguments: arguments,
+		encoded:   make(map[encoding]encodedArguments),
+		errs:      make(map[encoding]error),
+		frames:    make(map[encoding][]byte),
+	}
+}
+
+// encode returns the arguments encoded for client c
+func (e *argumentEncoder) encode(c *Client) (encodedArguments, error) {
+	codec, attach := c.Codec(), c.binaryAttachments()
+	key := encoding{codec: codec.Name(), attach: attach}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.encodeLocked(codec, key)
+}
+
+// message returns the event sent to client c on channel. The message is
+// encoded once for each encoding, and the returned Message carries the
+// encoded frame, so Conn.WriteMessage does not encode it again.
+func (e *argumentEncoder) message(c *Client, channel string) (*Message, error) {
+	codec, attach := c.Codec(), c.binaryAttachments()
+	key := encoding{codec: codec.Name(), attach: attach}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoded, err := e.encodeLocked(codec, key)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		Channel:     channel,
+		Arguments:   encoded.values,
+		Attachments: encoded.attachments,
+	}
+	frame, ok := e.frames[key]
+	if !ok {
+		frame, err = codec.MarshalMessage(msg)
+		if err != nil {
+			e.errs[key] = err
+			return nil, err
+		}
+		e.frames[key] = frame
+	}
+	msg.frame, msg.frameCodec = frame, codec
+	return msg, nil
+}
+
+// encodeLocked returns the arguments encoded with codec for key. e.mu must be
+// held by the caller.
+func (e *argumentEncoder) encodeLocked(
+	codec Codec, key encoding,
+) (encodedArguments, error) {
+	if encoded, ok := e.encoded[key]; ok {
+		return encoded, nil
+	} else if err, ok := e.errs[key]; ok {
+		return encodedArguments{}, err
+	}
+	encoded, err := encodeArguments(codec, e.arguments, key.attach)
+	if err != nil {
+		e.errs[key] = err
+		return encodedArguments{}, err
+	}
+	e.encoded[key] = encoded
+	return encoded, nil
+}
@@ -0,0 +1,106 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+// wrappingCodec is a JSON codec that encodes every value wrapped in an array,
+// so values it encodes can be told apart from plain JSON.
+type wrappingCodec struct {
+	jsonCodec
+}
+
+func (wrappingCodec) Name() string {
+	return "wrapped"
+}
+
+func (wrappingCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal([]any{v})
+}
+
+func (wrappingCodec) Unmarshal(data []byte, v any) error {
+	var wrapped []json.RawMessage
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return err
+	}
+	return json.Unmarshal(wrapped[0], v)
+}
+
+// codecConn is a mockConn that uses a specific codec
+type codecConn struct {
+	*mockConn
+	codec Codec
+}
+
+func (c codecConn) Codec() Codec {
+	return c.codec
+}
+
+// TestCodecFor verifies that registered codecs are returned by name and that
+// JSON is returned for unknown names.
+func TestCodecFor(t *testing.T) {
+	if CodecFor("") != JSON || CodecFor("unknown") != JSON {
+		t.Fatal("expected JSON codec for unknown name")
+	}
+	RegisterCodec(wrappingCodec{})
+	if CodecFor("wrapped") != (wrappingCodec{}) {
+		t.Fatal("expected registered codec")
+	}
+	if !slices.Contains(Codecs(), "json") || !slices.Contains(Codecs(), "wrapped") {
+		t.Fatalf("expected json and wrapped codecs, got %v", Codecs())
+	}
+}
+
+// TestClientCodec verifies that handlers receive arguments decoded with the
+// connection's codec and that broadcasts are encoded for each client's codec.
+func TestClientCodec(t *testing.T) {
+	server := NewServer()
+	server.On("double", func(n int) (int, error) {
+		return n * 2, nil
+	})
+	jsonConn := newMockConn()
+	wrappedConn := codecConn{newMockConn(), wrappingCodec{}}
+	acceptClient(t, server, jsonConn)
+	var client *Client
+	server.Once("open", func(c *Client) {
+		client = c
+	})
+	if err := server.Accept(wrappedConn); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if client == nil || client.Codec().Name() != "wrapped" {
+		t.Fatal("expected client to use the connection's codec")
+	}
+
+	reqID := 1
+	wrappedConn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{
+			[]byte(`["double"]`), []byte(`[21]`),
+		},
+	})
+	resp := wrappedConn.waitWritten(t, time.Second)
+	if resp.ResponseError != nil || resp.ResponseData != 42 {
+		t.Fatalf("expected 42, got %+v", resp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Emit(ctx, "news", "headline"); err != nil {
+		t.Fatal(err)
+	}
+	for conn, expected := range map[*mockConn]string{
+		jsonConn:             `"headline"`,
+		wrappedConn.mockConn: `["headline"]`,
+	} {
+		msg := conn.waitWritten(t, time.Second)
+		if len(msg.Arguments) != 2 || string(msg.Arguments[1]) != expected {
+			t.Fatalf("expected argument %s, got %+v", expected, msg)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// This package provides a MessagePack codec for the ws-server-wrapper library.
+// Messages are encoded with MessagePack and sent as binary WebSocket frames,
+// which are more compact and faster to encode than JSON.
+//
+// Importing this package registers the codec with the name "msgpack", so
+// connections that negotiate the "msgpack" WebSocket subprotocol use it
+// automatically (see wrapper.CodecFor).
+package msgpack
+
+import (
+	"bytes"
+	"encoding/json"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// Codec is the MessagePack codec. Struct fields are named by their `msgpack`
+// tags, falling back to their `json` tags, so types used with the JSON codec
+// are encoded the same way. Integers and floats in values decoded into an
+// interface are returned as int64, uint64 and float64.
+var Codec wrapper.Codec = codec{}
+
+func init() {
+	wrapper.RegisterCodec(Codec)
+}
+
+// frame is the MessagePack encoding of a wrapper.Message. It uses the same
+// keys as the JSON encoding. D is the type of the response data: any when
+// encoding, and msgpack.RawMessage when decoding, so the encoded response data
+// is retained (see wrapper.Message.SetRawResponseData).
+type frame[D any] struct {
+	Channel         string               `msgpack:"c,omitempty"`
+	Arguments       []msgpack.RawMessage `msgpack:"a,omitempty"`
+	RequestID       *int                 `msgpack:"i,omitempty"`
+	ResponseData    D                    `msgpack:"d,omitempty"`
+	ResponseError   any                  `msgpack:"e,omitempty"`
+	ResponseJSError bool                 `msgpack:"_,omitempty"`
+	CancelReason    any                  `msgpack:"x,omitempty"`
+	StreamChunk     bool                 `msgpack:"s,omitempty"`
+	Progress        any                  `msgpack:"p,omitempty"`
+	IgnoreIfFalse   *bool                `msgpack:"ws-wrapper,omitempty"`
+	Version         string               `msgpack:"v,omitempty"`
+	Capabilities    []string             `msgpack:"caps,omitempty"`
+	Session         string               `msgpack:"sid,omitempty"`
+	Sequence        uint64               `msgpack:"n,omitempty"`
+}
+
+// codec implements the wrapper.Codec interface
+type codec struct{}
+
+func (codec) Name() string {
+	return "msgpack"
+}
+
+func (codec) Binary() bool {
+	return true
+}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
+
+func (c codec) MarshalMessage(msg *wrapper.Message) ([]byte, error) {
+	f := frame[any]{
+		Channel:         msg.Channel,
+		RequestID:       msg.RequestID,
+		ResponseData:    msg.ResponseData,
+		ResponseError:   msg.ResponseError,
+		ResponseJSError: bool(msg.ResponseJSError),
+		CancelReason:    msg.CancelReason,
+		StreamChunk:     bool(msg.StreamChunk),
+		Progress:        msg.Progress,
+		Version:         msg.Version,
+		Capabilities:    msg.Capabilities,
+		Session:         msg.Session,
+		Sequence:        msg.Sequence,
+	}
+	if msg.IgnoreIfFalse != nil {
+		ignoreIfFalse := bool(*msg.IgnoreIfFalse)
+		f.IgnoreIfFalse = &ignoreIfFalse
+	}
+	if msg.Arguments != nil {
+		f.Arguments = make([]msgpack.RawMessage, len(msg.Arguments))
+		for i, arg := range msg.Arguments {
+			f.Arguments[i] = msgpack.RawMessage(arg)
+		}
+	}
+	return c.Marshal(&f)
+}
+
+func (c codec) UnmarshalMessage(data []byte, msg *wrapper.Message) error {
+	var f frame[msgpack.RawMessage]
+	if err := c.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.IgnoreIfFalse != nil && !*f.IgnoreIfFalse {
+		// Leave msg empty so that it is ignored, unless it is a hello or
+		// session message
+		msg.Version = f.Version
+		msg.Capabilities = f.Capabilities
+		msg.Session = f.Session
+		msg.Sequence = f.Sequence
+		return nil
+	}
+	msg.Channel = f.Channel
+	msg.RequestID = f.RequestID
+	msg.ResponseData = nil
+	msg.SetRawResponseData(f.ResponseData)
+	if f.ResponseData != nil {
+		if err := c.Unmarshal(f.ResponseData, &msg.ResponseData); err != nil {
+			return err
+		}
+	}
+	msg.ResponseError = f.ResponseError
+	msg.ResponseJSError = false
+	if f.ResponseJSError {
+		msg.ResponseJSError = true
+	}
+	msg.CancelReason = f.CancelReason
+	msg.StreamChunk = false
+	if f.StreamChunk {
+		msg.StreamChunk = true
+	}
+	msg.Progress = f.Progress
+	msg.Sequence = f.Sequence
+	msg.Arguments = nil
+	if f.Arguments != nil {
+		msg.Arguments = make([]json.RawMessage, len(f.Arguments))
+		for i, arg := range f.Arguments {
+			msg.Arguments[i] = json.RawMessage(arg)
+		}
+	}
+	return nil
+}
+
+// ExceedsDepth returns true if arrays and maps in the MessagePack value data
+// are nested more than maxDepth levels deep. It implements wrapper.DepthCodec,
+// so wrapper.Limits.MaxDepth is enforced for MessagePack connections. Malformed
+// data is left to Unmarshal to reject.
+func (codec) ExceedsDepth(data []byte, maxDepth int) bool {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+	dec.Reset(bytes.NewReader(data))
+	// remaining holds the number of values left in each enclosing array or map
+	var remaining []int
+	for {
+		code, err := dec.PeekCode()
+		if err != nil {
+			return false
+		}
+		n := -1 // number of values in the array or map; -1 for other values
+		switch {
+		case msgpcode.IsFixedArray(code) ||
+			code == msgpcode.Array16 || code == msgpcode.Array32:
+			n, err = dec.DecodeArrayLen()
+		case msgpcode.IsFixedMap(code) ||
+			code == msgpcode.Map16 || code == msgpcode.Map32:
+			n, err = dec.DecodeMapLen()
+			n *= 2 // keys and values
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return false
+		}
+		if len(remaining) > 0 {
+			remaining[len(remaining)-1]--
+		}
+		if n >= 0 {
+			if len(remaining) >= maxDepth {
+				return true
+			}
+			remaining = append(remaining, n)
+		}
+		for len(remaining) > 0 && remaining[len(remaining)-1] == 0 {
+			remaining = remaining[:len(remaining)-1]
+		}
+		if len(remaining) == 0 {
+			return false
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package msgpack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	wrapper "github.com/bminer/ws-server-wrapper-go"
+)
+
+// pipeConn is one end of an in-memory connection that passes frames encoded
+// by a codec to the other end.
+type pipeConn struct {
+	codec   wrapper.Codec
+	readCh  <-chan []byte
+	writeCh chan<- []byte
+	closeCh chan struct{}
+}
+
+// newPipe returns both ends of an in-memory connection using codec
+func newPipe(codec wrapper.Codec) (*pipeConn, *pipeConn) {
+	ab := make(chan []byte, 16)
+	ba := make(chan []byte, 16)
+	closeCh := make(chan struct{})
+	return &pipeConn{codec, ba, ab, closeCh}, &pipeConn{codec, ab, ba, closeCh}
+}
+
+func (p *pipeConn) Codec() wrapper.Codec {
+	return p.codec
+}
+
+func (p *pipeConn) ReadMessage(ctx context.Context, msg *wrapper.Message) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closeCh:
+		return context.Canceled
+	case data := <-p.readCh:
+		return p.codec.UnmarshalMessage(data, msg)
+	}
+}
+
+func (p *pipeConn) WriteMessage(ctx context.Context, msg *wrapper.Message) error {
+	data, err := p.codec.MarshalMessage(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closeCh:
+		return context.Canceled
+	case p.writeCh <- data:
+		return nil
+	}
+}
+
+func (p *pipeConn) Close(_ wrapper.StatusCode, _ string) error {
+	select {
+	case <-p.closeCh:
+	default:
+		close(p.closeCh)
+	}
+	return nil
+}
+
+func (p *pipeConn) CloseNow() error {
+	return p.Close(0, "")
+}
+
+// TestCodecRegistered verifies that importing the package registers the codec
+func TestCodecRegistered(t *testing.T) {
+	if wrapper.CodecFor("msgpack") != Codec {
+		t.Fatal("expected msgpack codec to be registered")
+	}
+}
+
+// TestCodecRoundTrip verifies that messages survive encoding and decoding
+func TestCodecRoundTrip(t *testing.T) {
+	arg, err := Codec.Marshal("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqID := 3
+	msg := wrapper.Message{
+		Channel:         "chat",
+		Arguments:       []json.RawMessage{arg},
+		RequestID:       &reqID,
+		ResponseError:   map[string]any{"message": "oops"},
+		ResponseJSError: true,
+		StreamChunk:     true,
+		Sequence:        7,
+	}
+	data, err := Codec.MarshalMessage(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded wrapper.Message
+	if err := Codec.UnmarshalMessage(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Channel != "chat" || decoded.RequestID == nil ||
+		*decoded.RequestID != reqID || !bool(decoded.ResponseJSError) ||
+		!bool(decoded.StreamChunk) || decoded.Sequence != 7 {
+		t.Fatalf("unexpected message %+v", decoded)
+	}
+	if !reflect.DeepEqual(decoded.ResponseError, msg.ResponseError) {
+		t.Fatalf("expected error %v, got %v", msg.ResponseError, decoded.ResponseError)
+	}
+	var name string
+	if err := Codec.Unmarshal(decoded.Arguments[0], &name); err != nil || name != "echo" {
+		t.Fatalf("expected event name %q, got %q (%v)", "echo", name, err)
+	}
+}
+
+// TestRequest verifies that requests and responses between a server and a
+// client are encoded with the codec.
+func TestRequest(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.On("move", func(p point, dx int) (point, error) {
+		return point{X: p.X + dx, Y: p.Y}, nil
+	})
+	server.On("fail", func() error {
+		return errors.New("failed")
+	})
+	server.On("ratio", func() (float32, error) {
+		return 1.5, nil
+	})
+
+	serverConn, clientConn := newPipe(Codec)
+	if err := server.Accept(serverConn); err != nil {
+		t.Fatal(err)
+	}
+	client := wrapper.NewClient(clientConn)
+	defer client.Close(wrapper.StatusNormalClosure, "")
+	if client.Codec() != Codec {
+		t.Fatalf("expected msgpack codec, got %s", client.Codec().Name())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p, err := wrapper.Call[point](ctx, client, "move", point{X: 1, Y: 2}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{X: 4, Y: 2}) {
+		t.Fatalf("expected {4 2}, got %v", p)
+	}
+	// float32 values are decoded into an interface as float64, which cannot
+	// be decoded into a float32 again, so the raw response must be used
+	ratio, err := wrapper.Call[float32](ctx, client, "ratio")
+	if err != nil || ratio != 1.5 {
+		t.Fatalf("expected 1.5, got %v (%v)", ratio, err)
+	}
+	data, err := client.Request(ctx, "move", point{X: 1, Y: 2}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"x": int64(4), "y": int64(2)}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("expected %v, got %#v", expected, data)
+	}
+
+	_, err = client.Request(ctx, "fail")
+	var remoteErr *wrapper.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Message != "failed" {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+}
+
+// TestExceedsDepth verifies that the nesting depth of arrays and maps is
+// checked and that Limits.MaxDepth is enforced for MessagePack connections.
+func TestExceedsDepth(t *testing.T) {
+	tests := []struct {
+		value any
+		depth int
+	}{
+		{"text", 0},
+		{[]any{}, 1},
+		{[]any{1, "a", nil}, 1},
+		{map[string]any{"a": []any{1, 2}}, 2},
+		{[]any{[]any{}, map[string]any{"a": []any{[]any{1}}}, 3}, 4},
+		{[]any{[]any{1}, []any{2}, []any{3}}, 2},
+	}
+	for _, test := range tests {
+		data, err := Codec.Marshal(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dc := Codec.(wrapper.DepthCodec)
+		if test.depth > 0 && !dc.ExceedsDepth(data, test.depth-1) {
+			t.Errorf("%v: expected depth %d to be exceeded", test.value, test.depth-1)
+		}
+		if dc.ExceedsDepth(data, max(test.depth, 1)) {
+			t.Errorf("%v: expected depth %d not to be exceeded", test.value, test.depth)
+		}
+	}
+
+	server := wrapper.NewServer()
+	defer server.Close()
+	server.SetLimits(wrapper.Limits{MaxDepth: 2})
+	server.On("echo", func(v any) (any, error) {
+		return v, nil
+	})
+	serverConn, clientConn := newPipe(Codec)
+	if err := server.Accept(serverConn); err != nil {
+		t.Fatal(err)
+	}
+	client := wrapper.NewClient(clientConn)
+	defer client.Close(wrapper.StatusNormalClosure, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Request(ctx, "echo", []any{[]any{1}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.Request(ctx, "echo", []any{[]any{[]any{1}}})
+	if err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Fatalf("expected depth limit error, got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/bminer/ws-server-wrapper-go/codecs/msgpack
+
+go 1.23.4
+
+require (
+	github.com/bminer/ws-server-wrapper-go v0.0.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+
+replace github.com/bminer/ws-server-wrapper-go => ../..
@@ -17,3 +17,14 @@ func ClientFromContext(ctx context.Context) *Client {
 	}
 	return c
 }
+
+// requestIDKey is the context key for the ID of the inbound request being
+// handled. See ReportProgress.
+const requestIDKey = contextKey("requestID")
+
+// requestIDFromContext returns the ID of the inbound request being handled or
+// nil if ctx is not the context of a request handler.
+func requestIDFromContext(ctx context.Context) *int {
+	id, _ := ctx.Value(requestIDKey).(*int)
+	return id
+}
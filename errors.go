@@ -1,6 +1,33 @@
 package wrapper
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuorumNotReached indicates that too few clients responded successfully to
+// a request sent with RequestOptions.Quorum.
+var ErrQuorumNotReached = errors.New("quorum not reached")
+
+// ErrSendQueueFull indicates that a message could not be sent because the
+// client's send queue is full. See OverflowPolicy.
+var ErrSendQueueFull = errors.New("send queue is full")
+
+// ErrStreamBufferFull indicates that a stream requested with
+// ClientChannel.RequestStream was cancelled because its caller did not keep up
+// with the chunks sent by the remote end.
+var ErrStreamBufferFull = errors.New("stream buffer is full")
+
+// WrapperError is implemented by errors that are sent to the remote end as a
+// JavaScript Error object. When an event handler returns an error that
+// implements WrapperError (or wraps one; see errors.As), the object returned
+// by WrapperError is sent to the remote end as the error, so properties such
+// as "name" and "code" are preserved. If the object has no "message"
+// property, the error's message is added.
+type WrapperError interface {
+	error
+	WrapperError() map[string]any
+}
 
 // ClientError is an error for a specific client
 type ClientError struct {
@@ -30,3 +57,50 @@ func (e ChannelClosedError) Error() string {
 	}
 	return fmt.Sprintf("channel '%s' is closed", e.Channel)
 }
+
+// CloseError indicates that the remote end closed the connection with the
+// given status code and reason. A Conn returns it (or an error wrapping it)
+// from ReadMessage after receiving a close frame; the Client is then closed
+// with the same status code and reason instead of StatusInternalError.
+type CloseError struct {
+	Code   StatusCode
+	Reason string
+}
+
+// Error returns the error message as a string.
+func (e CloseError) Error() string {
+	return fmt.Sprintf("connection closed (status: %d, reason: %q)", e.Code, e.Reason)
+}
+
+// RemoteError is an error sent by the remote end, either as the response to a
+// request or as the reason a request was cancelled. Use errors.As to inspect
+// the details of the error.
+type RemoteError struct {
+	// Message is the error message
+	Message string
+	// JSError is true if the error was sent as a JavaScript Error object
+	// (the "_" flag of the message was set) rather than as a string.
+	JSError bool
+	// Object is the full error object sent by the remote end, including its
+	// "message" and any other properties (i.e. "name", "code", or "stack").
+	// Object is nil if JSError is false.
+	Object map[string]any
+}
+
+// Error returns the error message
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Name returns the "name" property of the JavaScript error (i.e. "TypeError")
+// or an empty string if it is not set.
+func (e *RemoteError) Name() string {
+	name, _ := e.Object["name"].(string)
+	return name
+}
+
+// Code returns the "code" property of the JavaScript error or nil if it is not
+// set. Depending on the remote end, the code may be a string or a float64.
+func (e *RemoteError) Code() any {
+	return e.Object["code"]
+}
@@ -0,0 +1,150 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// HandlerRegistry is a channel to which event handlers can be added. It is
+// implemented by ClientChannel and ServerChannel, and therefore by *Client and
+// *Server as well. It is used by Handle and its variants.
+type HandlerRegistry interface {
+	Name() string
+	setHandler(eventName string, handler any, once bool)
+}
+
+// typedHandler is an event handler created by Handle or one of its variants.
+// It decodes the event arguments itself, so callHandler can call it without
+// using reflection.
+type typedHandler func(
+	ctx context.Context, codec Codec, arguments []json.RawMessage,
+	policy ArgumentPolicy,
+) (any, error)
+
+// Handle0 adds an event handler that takes no arguments to the channel. See
+// Handle for more information.
+func Handle0[Resp any](
+	ch HandlerRegistry,
+	eventName string,
+	handler func(context.Context) (Resp, error),
+) {
+	handleTyped(ch, eventName, 0,
+		func(ctx context.Context, decode decodeFunc) (any, error) {
+			return handler(ctx)
+		},
+	)
+}
+
+// Handle adds an event handler that takes a single argument to the channel.
+// Unlike ClientChannel.On, the handler's signature is checked at compile time
+// and the argument is decoded directly into a Req without using reflection to
+// call the handler. The handler's return value is sent as the response to a
+// request. For example:
+//
+//	wrapper.Handle(s, "add", func(ctx context.Context, nums []int) (int, error) {
+//	    sum := 0
+//	    for _, n := range nums {
+//	        sum += n
+//	    }
+//	    return sum, nil
+//	})
+//
+// Handlers added with Handle follow the same priority rules as handlers added
+// with ClientChannel.On. Handle panics if eventName is a reserved event on the
+// main channel.
+func Handle[Req, Resp any](
+	ch HandlerRegistry,
+	eventName string,
+	handler func(context.Context, Req) (Resp, error),
+) {
+	handleTyped(ch, eventName, 1,
+		func(ctx context.Context, decode decodeFunc) (any, error) {
+			var req Req
+			if err := decode(&req); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		},
+	)
+}
+
+// Handle2 adds an event handler that takes two arguments to the channel. See
+// Handle for more information.
+func Handle2[A, B, Resp any](
+	ch HandlerRegistry,
+	eventName string,
+	handler func(context.Context, A, B) (Resp, error),
+) {
+	handleTyped(ch, eventName, 2,
+		func(ctx context.Context, decode decodeFunc) (any, error) {
+			var a A
+			var b B
+			if err := decode(&a, &b); err != nil {
+				return nil, err
+			}
+			return handler(ctx, a, b)
+		},
+	)
+}
+
+// Handle3 adds an event handler that takes three arguments to the channel. See
+// Handle for more information.
+func Handle3[A, B, C, Resp any](
+	ch HandlerRegistry,
+	eventName string,
+	handler func(context.Context, A, B, C) (Resp, error),
+) {
+	handleTyped(ch, eventName, 3,
+		func(ctx context.Context, decode decodeFunc) (any, error) {
+			var a A
+			var b B
+			var c C
+			if err := decode(&a, &b, &c); err != nil {
+				return nil, err
+			}
+			return handler(ctx, a, b, c)
+		},
+	)
+}
+
+// decodeFunc decodes the arguments of an event into the values pointed to by
+// the passed pointers, in order
+type decodeFunc func(values ...any) error
+
+// handleTyped adds a typedHandler for a handler that takes numArgs arguments
+// to the channel. The number of arguments is checked against the argument
+// policy before call is called; call decodes the arguments with decode and
+// calls the handler.
+func handleTyped(
+	ch HandlerRegistry,
+	eventName string,
+	numArgs int,
+	call func(ctx context.Context, decode decodeFunc) (any, error),
+) {
+	ch.setHandler(eventName, typedHandler(func(
+		ctx context.Context, codec Codec, arguments []json.RawMessage,
+		policy ArgumentPolicy,
+	) (any, error) {
+		arguments, err := fitArguments(arguments, numArgs, false, policy)
+		if err != nil {
+			return nil, err
+		}
+		return call(ctx, func(values ...any) error {
+			for i, v := range values {
+				if err := decodeArgument(codec, arguments[i], v); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}), false)
+}
+
+// decodeArgument decodes an encoded argument into v. Missing arguments (see
+// AllowMissingArguments) are nil and leave v unchanged.
+func decodeArgument(codec Codec, argument json.RawMessage, v any) error {
+	if argument == nil {
+		return nil
+	}
+	return codec.Unmarshal(argument, v)
+}
@@ -0,0 +1,137 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHandle verifies that typed handlers receive decoded arguments and that
+// their results are sent as responses.
+func TestHandle(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	type point struct {
+		X, Y int
+	}
+	Handle0(server, "ping", func(ctx context.Context) (string, error) {
+		return "pong", nil
+	})
+	Handle(server.Of("math"), "sum", func(ctx context.Context, nums []int) (int, error) {
+		sum := 0
+		for _, n := range nums {
+			sum += n
+		}
+		return sum, nil
+	})
+	Handle2(server, "move", func(ctx context.Context, p point, dx int) (point, error) {
+		if ClientFromContext(ctx) == nil {
+			t.Error("expected client in handler context")
+		}
+		return point{X: p.X + dx, Y: p.Y}, nil
+	})
+	Handle3(server, "join", func(ctx context.Context, a, b, sep string) (string, error) {
+		return a + sep + b, nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	tests := []struct {
+		channel   string
+		arguments []string
+		expected  any
+	}{
+		{"", []string{`"ping"`}, "pong"},
+		{"math", []string{`"sum"`, `[1,2,3]`}, 6},
+		{"", []string{`"move"`, `{"X":1,"Y":2}`, `3`}, point{X: 4, Y: 2}},
+		{"", []string{`"join"`, `"a"`, `"b"`, `"-"`}, "a-b"},
+	}
+	for i, test := range tests {
+		reqID := i + 1
+		arguments := make([]json.RawMessage, len(test.arguments))
+		for j, arg := range test.arguments {
+			arguments[j] = json.RawMessage(arg)
+		}
+		conn.send(Message{
+			Channel:   test.channel,
+			RequestID: &reqID,
+			Arguments: arguments,
+		})
+		resp := conn.waitWritten(t, time.Second)
+		if resp.RequestID == nil || *resp.RequestID != reqID {
+			t.Fatalf("expected response for request %d, got %+v", reqID, resp)
+		}
+		if !reflect.DeepEqual(resp.ResponseData, test.expected) {
+			t.Fatalf("expected %v, got %+v", test.expected, resp)
+		}
+	}
+}
+
+// TestHandleErrors verifies that typed handlers reject arguments that cannot
+// be decoded and panic when added for a reserved event.
+func TestHandleErrors(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	Handle(server, "double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	for i, arguments := range [][]json.RawMessage{
+		{[]byte(`"double"`), []byte(`"two"`)},
+		{[]byte(`"double"`), []byte(`1`), []byte(`2`)},
+	} {
+		reqID := i + 1
+		conn.send(Message{RequestID: &reqID, Arguments: arguments})
+		resp := conn.waitWritten(t, time.Second)
+		if resp.ResponseError == nil {
+			t.Fatalf("expected error response, got %+v", resp)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for reserved event")
+		}
+	}()
+	Handle0(server, "open", func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, nil
+	})
+}
+
+// TestHandleArgumentPolicy verifies that typed handlers follow the server's
+// argument policy.
+func TestHandleArgumentPolicy(t *testing.T) {
+	server := NewServer()
+	server.SetArgumentPolicy(AllowMissingArguments | AllowExtraArguments)
+	conn := newMockConn()
+
+	Handle2(server, "greet", func(ctx context.Context, name string, n int) (string, error) {
+		return strings.Repeat("hi "+name+" ", n), nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	for i, test := range []struct {
+		arguments []json.RawMessage
+		expected  string
+	}{
+		{[]json.RawMessage{[]byte(`"greet"`), []byte(`"Al"`)}, ""},
+		{[]json.RawMessage{
+			[]byte(`"greet"`), []byte(`"Al"`), []byte(`1`), []byte(`null`),
+		}, "hi Al "},
+	} {
+		reqID := i + 1
+		conn.send(Message{RequestID: &reqID, Arguments: test.arguments})
+		resp := conn.waitWritten(t, time.Second)
+		if resp.ResponseError != nil || resp.ResponseData != test.expected {
+			t.Fatalf("expected %q, got %+v", test.expected, resp)
+		}
+	}
+}
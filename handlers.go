@@ -65,30 +65,87 @@ type MessageHandler = func(*Client, Message)
 type CloseHandler = func(*Client, StatusCode, string, bool)
 type CloseHandlerOld = func(*Client, StatusCode, string)
 
-// Calls the handler function with the given arguments. Passes the Context to
-// the handler if the first argument is a Context. The handler function must
-// return two values: the result and an error. This function returns the result
-// and error from the handler function.
-func callHandler(
-	ctx context.Context, handler any, arguments []json.RawMessage,
-) (res any, err error) {
-	handlerV := reflect.ValueOf(handler)
-	handlerT := handlerV.Type()
+// RoomHandler is called when a client joins or leaves a Room. See
+// Server.OnRoomJoin and Server.OnRoomLeave.
+type RoomHandler = func(Room, *Client)
 
-	// Ensure handler is a function with the correct number of parameters
-	if handlerT.Kind() != reflect.Func {
-		return nil, errors.New("handler is not a function")
+// ArgumentPolicy determines how event handlers are called when the number of
+// arguments received differs from the number of handler parameters.
+// Policies may be combined with a bitwise OR.
+type ArgumentPolicy uint8
+
+const (
+	// ArgumentsExact requires the number of arguments to match the number of
+	// handler parameters. This is the default.
+	ArgumentsExact ArgumentPolicy = 0
+	// AllowMissingArguments passes the zero value for missing trailing
+	// arguments, which JavaScript clients omit if they are undefined.
+	AllowMissingArguments ArgumentPolicy = 1
+	// AllowExtraArguments ignores extra trailing arguments.
+	AllowExtraArguments ArgumentPolicy = 2
+)
+
+// fitArguments returns arguments adjusted to the number of handler parameters
+// according to policy. If variadic is true, the last parameter is variadic and
+// accepts any number of arguments. Missing arguments are nil. Returns an error
+// if the number of arguments is not allowed by policy.
+func fitArguments(
+	arguments []json.RawMessage, numParams int, variadic bool,
+	policy ArgumentPolicy,
+) ([]json.RawMessage, error) {
+	if variadic {
+		numParams--
+	}
+	if len(arguments) < numParams {
+		if policy&AllowMissingArguments == 0 {
+			return nil, argumentCountError(len(arguments), numParams, variadic)
+		}
+		fitted := make([]json.RawMessage, numParams)
+		copy(fitted, arguments)
+		return fitted, nil
+	}
+	if len(arguments) > numParams && !variadic {
+		if policy&AllowExtraArguments == 0 {
+			return nil, argumentCountError(len(arguments), numParams, variadic)
+		}
+		return arguments[:numParams], nil
 	}
+	return arguments, nil
+}
 
-	// Allow for optional Context as the first argument
-	numIn := handlerT.NumIn()
-	hasContext := numIn > 0 && handlerT.In(0) == contextType
-	argOffset := 0
-	if hasContext {
-		argOffset = 1
+// argumentCountError returns an error for an incorrect number of arguments
+func argumentCountError(received, expected int, variadic bool) error {
+	if variadic {
+		return fmt.Errorf(
+			"incorrect number of arguments: expected at least %d, received %d",
+			expected, received,
+		)
 	}
-	if len(arguments) != numIn-argOffset {
-		return nil, errors.New("incorrect number of arguments")
+	return fmt.Errorf(
+		"incorrect number of arguments: expected %d, received %d",
+		expected, received,
+	)
+}
+
+// invoker calls an event handler function. The handler's signature is
+// analyzed once by compileHandler, so calling it requires no further type
+// inspection.
+type invoker struct {
+	fn         reflect.Value
+	params     []reflect.Type // parameter types, excluding the context
+	hasContext bool           // first parameter is a context.Context
+	variadic   bool           // last parameter is variadic
+	hasResult  bool           // handler returns a result before the error
+}
+
+// compileHandler validates the signature of an event handler function and
+// returns an invoker for it. The handler may have a context.Context as its
+// first parameter and must return an error, optionally preceded by a result.
+func compileHandler(handler any) (*invoker, error) {
+	handlerV := reflect.ValueOf(handler)
+	handlerT := handlerV.Type()
+	if handlerT.Kind() != reflect.Func {
+		return nil, errors.New("handler is not a function")
 	}
 
 	// Ensure handler has the correct return values
@@ -106,27 +163,77 @@ func callHandler(
 		)
 	}
 
-	// Decode JSON arguments based on parameter type
-	ins := make([]reflect.Value, numIn)
-	if hasContext {
+	// Allow for optional Context as the first argument
+	inv := &invoker{
+		fn:        handlerV,
+		variadic:  handlerT.IsVariadic(),
+		hasResult: errOffset > 0,
+	}
+	for i := range handlerT.NumIn() {
+		paramT := handlerT.In(i)
+		if paramT == contextType {
+			if i > 0 {
+				return nil, errors.New(
+					"handler's context.Context must be its first parameter",
+				)
+			}
+			inv.hasContext = true
+			continue
+		}
+		inv.params = append(inv.params, paramT)
+	}
+	if inv.variadic {
+		// Arguments are decoded into elements of the variadic slice
+		last := len(inv.params) - 1
+		inv.params[last] = inv.params[last].Elem()
+	}
+	return inv, nil
+}
+
+// call calls the handler with the given arguments, which are decoded with
+// codec. Passes ctx to the handler if its first parameter is a Context.
+// Returns the result and error from the handler function. policy determines
+// how a mismatched number of arguments is handled.
+func (inv *invoker) call(
+	ctx context.Context, codec Codec, arguments []json.RawMessage,
+	policy ArgumentPolicy,
+) (res any, err error) {
+	arguments, err = fitArguments(
+		arguments, len(inv.params), inv.variadic, policy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode arguments based on parameter type
+	argOffset := 0
+	if inv.hasContext {
+		argOffset = 1
+	}
+	ins := make([]reflect.Value, argOffset+len(arguments))
+	if inv.hasContext {
 		ins[0] = reflect.ValueOf(ctx)
 	}
 	for i := range arguments {
-		paramT := handlerT.In(argOffset + i) // function parameter type
+		paramT := inv.params[min(i, len(inv.params)-1)]
 		argV := reflect.New(paramT)
-		err = json.Unmarshal(arguments[i], argV.Interface())
-		if err != nil {
-			return nil, err
+		if arguments[i] != nil { // missing arguments are zero values
+			err = codec.Unmarshal(arguments[i], argV.Interface())
+			if err != nil {
+				return nil, err
+			}
 		}
 		ins[argOffset+i] = argV.Elem()
 	}
 
 	// Call the handler
-	outs := handlerV.Call(ins)
+	outs := inv.fn.Call(ins)
 
 	// Convert output parameters
-	if errOffset > 0 {
+	errOffset := 0
+	if inv.hasResult {
 		res = outs[0].Interface()
+		errOffset = 1
 	}
 	errVal := outs[errOffset].Interface()
 	if errVal != nil {
@@ -136,6 +243,32 @@ func callHandler(
 	return res, err
 }
 
+// Calls the handler with the given arguments, which are decoded with codec.
+// handler is usually an *invoker or typedHandler stored by ClientChannel.On,
+// but any handler function is accepted. Returns the result and error from the
+// handler function. policy determines how a mismatched number of arguments is
+// handled.
+func callHandler(
+	ctx context.Context, handler any, codec Codec,
+	arguments []json.RawMessage, policy ArgumentPolicy,
+) (any, error) {
+	if codec == nil {
+		codec = JSON
+	}
+	switch h := handler.(type) {
+	case *invoker:
+		return h.call(ctx, codec, arguments, policy)
+	case typedHandler:
+		// Handlers added by Handle and its variants decode their own arguments
+		return h(ctx, codec, arguments, policy)
+	}
+	inv, err := compileHandler(handler)
+	if err != nil {
+		return nil, err
+	}
+	return inv.call(ctx, codec, arguments, policy)
+}
+
 // emitReserved calls all handlers on the main channel with the specified event
 // names. caller is called for each handler function; it is caller's
 // responsibility to cast f to the appropriate function type and call it.
@@ -244,26 +377,27 @@ func checkHandler(channel, eventName string, handler any) error {
 	}
 
 	// Perform validation for normal events
-	t := reflect.TypeOf(handler)
-	if t.Kind() != reflect.Func {
-		return errors.New("handler must be a function")
-	}
+	_, err := prepareHandler(channel, eventName, handler)
+	return err
+}
 
-	// Ensure handler has the correct return values
-	errOffset := 0
-	switch t.NumOut() {
-	case 1:
-	case 2:
-		errOffset = 1
-	default:
-		return errors.New("handler should return one or two values")
+// prepareHandler validates handler and returns the value stored in a handler
+// map. Handlers for normal events are compiled to an *invoker, so their
+// signatures are only analyzed once. Handlers for reserved events are stored
+// as-is.
+func prepareHandler(channel, eventName string, handler any) (any, error) {
+	if handler == nil {
+		return nil, nil
 	}
-	if !t.Out(errOffset).Implements(errorType) {
-		return errors.New(
-			"handler's last output type must implement error",
-		)
+	if channel == "" && IsReservedEvent(eventName) {
+		return handler, checkHandler(channel, eventName, handler)
 	}
-
-	// Passed validation
-	return nil
+	if _, ok := handler.(typedHandler); ok {
+		return handler, nil // signature checked at compile time
+	}
+	inv, err := compileHandler(handler)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
 }
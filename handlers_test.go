@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -15,6 +16,7 @@ func TestCallHandler(t *testing.T) {
 		Name      string
 		Handler   any
 		Arguments []any
+		Policy    ArgumentPolicy
 		Response  any
 		Error     error
 	}
@@ -104,7 +106,63 @@ func TestCallHandler(t *testing.T) {
 				return s, nil
 			},
 			Arguments: []any{"string"},
-			Error:     fmt.Errorf("incorrect number of arguments"),
+			Error: fmt.Errorf(
+				"incorrect number of arguments: expected 2, received 1",
+			),
+		},
+		{
+			Name: "Too many arguments",
+			Handler: func(s string) (string, error) {
+				return s, nil
+			},
+			Arguments: []any{"string", 42},
+			Error: fmt.Errorf(
+				"incorrect number of arguments: expected 1, received 2",
+			),
+		},
+		{
+			Name: "Missing arguments allowed",
+			Handler: func(s string, i int, p *int) (string, error) {
+				return fmt.Sprintf("%s %d %v", s, i, p), nil
+			},
+			Arguments: []any{"string"},
+			Policy:    AllowMissingArguments,
+			Response:  "string 0 <nil>",
+		},
+		{
+			Name: "Extra arguments allowed",
+			Handler: func(ctx context.Context, s string) (string, error) {
+				return s, nil
+			},
+			Arguments: []any{"string", 42, true},
+			Policy:    AllowExtraArguments,
+			Response:  "string",
+		},
+		{
+			Name: "Variadic handler",
+			Handler: func(ctx context.Context, sep string, s ...string) (string, error) {
+				return strings.Join(s, sep), nil
+			},
+			Arguments: []any{"-", "a", "b", "c"},
+			Response:  "a-b-c",
+		},
+		{
+			Name: "Variadic handler without variadic arguments",
+			Handler: func(sep string, s ...string) (int, error) {
+				return len(s), nil
+			},
+			Arguments: []any{"-"},
+			Response:  0,
+		},
+		{
+			Name: "Variadic handler with missing arguments",
+			Handler: func(sep string, s ...string) (int, error) {
+				return len(s), nil
+			},
+			Arguments: []any{},
+			Error: fmt.Errorf(
+				"incorrect number of arguments: expected at least 1, received 0",
+			),
 		},
 		{
 			Name: "Too many return values",
@@ -151,7 +209,7 @@ func TestCallHandler(t *testing.T) {
 			jsonArgs[i] = buf
 		}
 
-		res, err := callHandler(ctx, ht.Handler, jsonArgs)
+		res, err := callHandler(ctx, ht.Handler, JSON, jsonArgs, ht.Policy)
 		if ht.Error != nil {
 			if err == nil {
 				t.Errorf(name+": expected error %v", ht.Error)
@@ -179,7 +237,7 @@ func TestIsReservedEvent(t *testing.T) {
 			t.Errorf("expected %q to be a reserved event", name)
 		}
 	}
-	for _, name := range []string{"custom", "echo", "ping", ""} {
+	for _, name := range []string{"custom", "echo", "ping", "room:join", "room:leave", ""} {
 		if IsReservedEvent(name) {
 			t.Errorf("expected %q to not be a reserved event", name)
 		}
@@ -246,6 +304,36 @@ func TestCheckHandler(t *testing.T) {
 	if err := checkHandler("", "custom", h); err == nil {
 		t.Error("expected error for handler with wrong return type")
 	}
+
+	// Invalid: handler with a context that is not its first parameter
+	h = func(s string, ctx context.Context) error { return nil }
+	if err := checkHandler("", "custom", h); err == nil {
+		t.Error("expected error for handler with misplaced context")
+	}
+}
+
+// TestOnCompilesHandler verifies that On validates normal event handlers when
+// they are added and stores a compiled invoker.
+func TestOnCompilesHandler(t *testing.T) {
+	server := NewServer()
+	server.Of("io").On("read", func(ctx context.Context, name string) (string, error) {
+		return name, nil
+	})
+	h := server.handlers[handlerName{Channel: "io", Event: "read"}]
+	inv, ok := h.(*invoker)
+	if !ok {
+		t.Fatalf("expected *invoker, got %T", h)
+	}
+	if !inv.hasContext || !inv.hasResult || len(inv.params) != 1 {
+		t.Fatalf("unexpected invoker %+v", inv)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for invalid handler")
+		}
+	}()
+	server.On("bad", func(s string) {})
 }
 
 func TestClientChannelCloseRemovesChannelHandlers(t *testing.T) {
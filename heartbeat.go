@@ -0,0 +1,162 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHeartbeatTimeout indicates that the remote end did not answer a heartbeat
+// ping in time, so the connection is presumed dead. See Server.SetHeartbeat.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// pingEvent is the event name of requests sent as protocol-level pings
+const pingEvent = "ws-wrapper:ping"
+
+// PingConn is a Conn that can send WebSocket pings. The adapters in the
+// adapters subdirectory implement it.
+type PingConn interface {
+	Conn
+	// Ping sends a ping and waits for the matching pong or for ctx to be done
+	Ping(ctx context.Context) error
+}
+
+// heartbeatConfig holds the values set by SetHeartbeat
+type heartbeatConfig struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// newHeartbeatConfig returns a heartbeatConfig; timeout defaults to interval
+func newHeartbeatConfig(interval, timeout time.Duration) heartbeatConfig {
+	if timeout <= 0 {
+		timeout = interval
+	}
+	return heartbeatConfig{interval: max(interval, 0), timeout: timeout}
+}
+
+// SetHeartbeat enables a heartbeat for this Client, overriding the setting of
+// its Server (see Server.SetHeartbeat). The setting takes effect when a
+// connection is bound; for clients accepted by a Server, call SetHeartbeat
+// from the server's "open" handler.
+func (c *Client) SetHeartbeat(interval, timeout time.Duration) {
+	conf := newHeartbeatConfig(interval, timeout)
+	c.connReqMu.Lock()
+	c.heartbeatConf = &conf
+	c.connReqMu.Unlock()
+}
+
+// RTT returns the round-trip time measured by the most recent heartbeat ping,
+// or zero if no ping has been answered on the active connection.
+func (c *Client) RTT() time.Duration {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	return c.rtt
+}
+
+// startHeartbeat starts sending heartbeat pings on conn if enabled
+func (c *Client) startHeartbeat(conn Conn) {
+	var conf heartbeatConfig
+	if c.server != nil {
+		conf = c.server.heartbeatConfig()
+	}
+	c.connReqMu.Lock()
+	if c.heartbeatConf != nil {
+		conf = *c.heartbeatConf
+	}
+	ctx := c.ctx
+	active := c.conn == conn
+	c.connReqMu.Unlock()
+	if conf.interval == 0 || !active {
+		return
+	}
+	go c.heartbeat(ctx, conn, conf)
+}
+
+// heartbeat pings the remote end every conf.interval until ctx is done. If a
+// ping is not answered within conf.timeout, the Client is closed.
+func (c *Client) heartbeat(
+	ctx context.Context, conn Conn, conf heartbeatConfig,
+) {
+	ticker := time.NewTicker(conf.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, conf.timeout)
+		start := time.Now()
+		err := c.ping(pingCtx, conn)
+		cancel()
+		if ctx.Err() != nil {
+			return // connection was closed
+		}
+		if err == nil {
+			c.connReqMu.Lock()
+			if c.conn == conn {
+				c.rtt = time.Since(start)
+			}
+			c.connReqMu.Unlock()
+			continue
+		}
+
+		// Emit error and close the dead connection
+		err = fmt.Errorf("%w: %w", ErrHeartbeatTimeout, err)
+		c.emitError(err)
+		if c.server != nil {
+			c.server.emitError(c, err)
+		}
+		c.connReqMu.Lock()
+		active := c.conn == conn
+		c.connReqMu.Unlock()
+		if active {
+			c.closeDead(conn, conf.timeout)
+		}
+		return
+	}
+}
+
+// closeDead closes the Client with StatusHeartbeatTimeout. A close frame is
+// sent in case the remote end is still reading, but the close handshake is
+// abandoned after timeout, since a dead remote end never completes it.
+func (c *Client) closeDead(conn Conn, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = c.close(
+			StatusHeartbeatTimeout, ErrHeartbeatTimeout.Error(),
+			false, false, false,
+		)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		_ = conn.CloseNow()
+	}
+}
+
+// ping sends a WebSocket ping if conn implements PingConn. Otherwise, it sends
+// a request as a protocol-level ping. Any response to the request, including
+// an error because the remote end has no handler for it, shows that the remote
+// end is alive.
+func (c *Client) ping(ctx context.Context, conn Conn) error {
+	if pc, ok := conn.(PingConn); ok {
+		return pc.Ping(ctx)
+	}
+	arguments, err := encodeArguments(c.Codec(), []any{pingEvent}, false)
+	if err != nil {
+		return err
+	}
+	resp := c.doRequest(ctx, "", arguments, nil)
+	var remoteErr *RemoteError
+	if resp.Error != nil && !errors.As(resp.Error, &remoteErr) {
+		return resp.Error
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// pingConn is a mockConn that implements PingConn. It must be used as a
+// pointer, so that it is comparable.
+type pingConn struct {
+	*mockConn
+	ping func(ctx context.Context) error
+}
+
+func (c *pingConn) Ping(ctx context.Context) error {
+	return c.ping(ctx)
+}
+
+// TestHeartbeatProtocolPing verifies that requests are sent as pings when the
+// Conn does not implement PingConn and that any response counts as a pong.
+func TestHeartbeatProtocolPing(t *testing.T) {
+	server := NewServer()
+	server.SetHeartbeat(10*time.Millisecond, time.Second)
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	for range 2 {
+		msg := conn.waitWritten(t, time.Second)
+		if msg.EventName() != pingEvent || msg.RequestID == nil {
+			t.Fatalf("expected ping request, got %+v", msg)
+		}
+		// The remote end has no handler for the ping
+		conn.send(Message{
+			RequestID:     msg.RequestID,
+			ResponseError: "no event listener for 'ws-wrapper:ping'",
+		})
+	}
+	deadline := time.Now().Add(time.Second)
+	for client.RTT() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected RTT to be measured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestHeartbeatTimeout verifies that a client is closed when a ping is not
+// answered in time.
+func TestHeartbeatTimeout(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		conn func() Conn
+	}{
+		{"protocol", func() Conn { return newMockConn() }},
+		{"websocket", func() Conn {
+			return &pingConn{newMockConn(), func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetHeartbeat(10*time.Millisecond, 20*time.Millisecond)
+			errs := make(chan error, 1)
+			server.On("error", func(c *Client, err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			})
+			closeStatus := make(chan StatusCode, 1)
+			server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+				closeStatus <- status
+			})
+			if err := server.Accept(test.conn()); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			select {
+			case status := <-closeStatus:
+				if status != StatusHeartbeatTimeout {
+					t.Fatalf("expected StatusHeartbeatTimeout, got %v", status)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("client was not closed")
+			}
+			if err := <-errs; !errors.Is(err, ErrHeartbeatTimeout) {
+				t.Fatalf("expected ErrHeartbeatTimeout, got %v", err)
+			}
+		})
+	}
+}
+
+// TestHeartbeatWebSocketPing verifies that WebSocket pings are used when the
+// Conn implements PingConn.
+func TestHeartbeatWebSocketPing(t *testing.T) {
+	server := NewServer()
+	conn := &pingConn{newMockConn(), func(ctx context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}}
+	var client *Client
+	server.Once("open", func(c *Client) {
+		client = c
+		c.SetHeartbeat(5*time.Millisecond, 0)
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for client.RTT() < time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatal("expected RTT to be measured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case msg := <-conn.writeCh:
+		t.Fatalf("expected no protocol ping, got %+v", msg)
+	default:
+	}
+}
+
+// TestAnswerPing verifies that protocol-level pings are answered
+func TestAnswerPing(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"` + pingEvent + `"`)},
+	})
+	resp := conn.waitWritten(t, time.Second)
+	if resp.RequestID == nil || *resp.RequestID != reqID || resp.ResponseError != nil {
+		t.Fatalf("expected ping response, got %+v", resp)
+	}
+}
@@ -23,26 +23,11 @@ func main() {
 		return s, nil
 	})
 
-	// Create HTTP handler function that upgrades the HTTP connection to a
-	// WebSocket using the github.com/coder/websocket package.
-	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-			// options go here (i.e. cross-origin setting)...
-		})
-		if err != nil {
-			// websocket.Accept already writes the HTTP response
-			log.Println("WebSocket connection error:", err)
-			return
-		}
-		log.Println("WebSocket connection accepted")
-		// Attach the WebSocket connection to ws-server-wrapper and start
-		// listening for inbound messages.
-		err = wsServer.Accept(coder.Wrap(conn))
-		if err != nil {
-			// wsServer.Accept already closes the conn
-			log.Println("Error wrapping connection:", err)
-			return
-		}
+	// Create HTTP handler that upgrades the HTTP connection to a WebSocket
+	// using the github.com/coder/websocket package, attaches it to
+	// ws-server-wrapper, and starts listening for inbound messages.
+	h := coder.Handler(wsServer, coder.HandlerOptions{
+		// options go here (i.e. cross-origin setting)...
 	})
 
 	// Start the HTTP server
@@ -0,0 +1,144 @@
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded indicates that an inbound message exceeds one of the
+// configured Limits.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// ErrMessageTooBig indicates that an inbound message exceeds
+// Limits.MaxMessageSize. A ReadLimitConn returns an error wrapping it from
+// ReadMessage if a message exceeds its read limit.
+var ErrMessageTooBig = errors.New("message too big")
+
+// Limits restricts the size and shape of inbound messages, so that a remote
+// end cannot make the Client decode arbitrarily large or deeply nested
+// messages before any event handler runs. A zero value for any field means no
+// limit. See Server.SetLimits and Client.SetLimits.
+//
+// A message larger than MaxMessageSize, whether rejected by the Conn's read
+// limit or by the Client, closes the connection with StatusMessageTooBig. An
+// inbound request that exceeds any other limit is rejected with an error
+// wrapping ErrLimitExceeded; any other inbound event that exceeds a limit
+// closes the connection with StatusPolicyViolation.
+type Limits struct {
+	// MaxMessageSize is the maximum size in bytes of an inbound message. It is
+	// passed to the Conn's SetReadLimit method if the Conn implements
+	// ReadLimitConn, so that larger WebSocket frames are not read at all. The
+	// encoded arguments and binary attachments of each message are also
+	// checked against it. If zero, the Conn's default read limit is used.
+	MaxMessageSize int64
+	// MaxArguments is the maximum number of arguments of an inbound event,
+	// including the event name.
+	MaxArguments int
+	// MaxEventNameLength is the maximum length in bytes of an event name.
+	MaxEventNameLength int
+	// MaxDepth is the maximum nesting depth of arrays and objects within each
+	// argument. It is only enforced if the codec of the connection implements
+	// DepthCodec, as JSON and the MessagePack codec do.
+	MaxDepth int
+}
+
+// ReadLimitConn is a Conn that can limit the size of WebSocket frames read
+// from the connection. The adapters in the adapters subdirectory implement it.
+type ReadLimitConn interface {
+	Conn
+	// SetReadLimit sets the maximum size in bytes of a WebSocket frame read
+	// from the connection. If a larger frame is received, ReadMessage returns
+	// an error wrapping ErrMessageTooBig.
+	SetReadLimit(n int64)
+}
+
+// setReadLimit sets the read limit of conn to limits.MaxMessageSize if set
+func setReadLimit(conn Conn, limits Limits) {
+	if rl, ok := conn.(ReadLimitConn); ok && limits.MaxMessageSize > 0 {
+		rl.SetReadLimit(limits.MaxMessageSize)
+	}
+}
+
+// checkSize returns an error if msg is larger than l.MaxMessageSize
+func (l Limits) checkSize(msg Message) error {
+	if l.MaxMessageSize <= 0 {
+		return nil
+	}
+	var size int64
+	for _, arg := range msg.Arguments {
+		size += int64(len(arg))
+	}
+	for _, attachment := range msg.Attachments {
+		size += int64(len(attachment))
+	}
+	if size > l.MaxMessageSize {
+		return fmt.Errorf(
+			"%w: %w (%d bytes, max %d)",
+			ErrLimitExceeded, ErrMessageTooBig, size, l.MaxMessageSize,
+		)
+	}
+	return nil
+}
+
+// checkEvent returns an error if the inbound event msg with the given event
+// name exceeds the limits on arguments, event name length, or depth
+func (l Limits) checkEvent(msg Message, eventName string) error {
+	if l.MaxArguments > 0 && len(msg.Arguments) > l.MaxArguments {
+		return fmt.Errorf(
+			"%w: %d arguments (max %d)",
+			ErrLimitExceeded, len(msg.Arguments), l.MaxArguments,
+		)
+	}
+	if l.MaxEventNameLength > 0 && len(eventName) > l.MaxEventNameLength {
+		return fmt.Errorf(
+			"%w: event name is %d bytes (max %d)",
+			ErrLimitExceeded, len(eventName), l.MaxEventNameLength,
+		)
+	}
+	codec := msg.codec
+	if codec == nil {
+		codec = JSON
+	}
+	if dc, ok := codec.(DepthCodec); ok && l.MaxDepth > 0 {
+		for i, arg := range msg.HandlerArguments() {
+			if dc.ExceedsDepth(arg, l.MaxDepth) {
+				return fmt.Errorf(
+					"%w: argument %d is nested too deeply (max depth %d)",
+					ErrLimitExceeded, i+1, l.MaxDepth,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// exceedsDepth returns true if arrays and objects in the JSON value data are
+// nested more than maxDepth levels deep
+func exceedsDepth(data []byte, maxDepth int) bool {
+	depth := 0
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			if escaped {
+				escaped = false
+			} else if b == '\\' {
+				escaped = true
+			} else if b == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '[', '{':
+			depth++
+			if depth > maxDepth {
+				return true
+			}
+		case ']', '}':
+			depth--
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package wrapper
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLimitConn is a mockConn that records its read limit
+type readLimitConn struct {
+	*mockConn
+	limit chan int64
+}
+
+func (c readLimitConn) SetReadLimit(n int64) {
+	c.limit <- n
+}
+
+// TestExceedsDepth verifies the nesting depth of JSON values
+func TestExceedsDepth(t *testing.T) {
+	tests := []struct {
+		data     string
+		maxDepth int
+		expected bool
+	}{
+		{`1`, 1, false},
+		{`[1,2]`, 1, false},
+		{`[[1]]`, 1, true},
+		{`{"a":{"b":[]}}`, 3, false},
+		{`{"a":{"b":[]}}`, 2, true},
+		{`["[[[{{{"]`, 1, false},
+		{`["\"[[["]`, 1, false},
+	}
+	for _, test := range tests {
+		if exceedsDepth([]byte(test.data), test.maxDepth) != test.expected {
+			t.Errorf("exceedsDepth(%s, %d) != %v",
+				test.data, test.maxDepth, test.expected,
+			)
+		}
+	}
+}
+
+// TestLimitsRejectRequests verifies that requests exceeding limits are
+// rejected without calling the handler.
+func TestLimitsRejectRequests(t *testing.T) {
+	server := NewServer()
+	server.SetLimits(Limits{MaxArguments: 3, MaxEventNameLength: 8, MaxDepth: 2})
+	conn := newMockConn()
+	called := make(chan struct{}, 10)
+	handler := func(args ...any) error {
+		called <- struct{}{}
+		return nil
+	}
+	server.On("echo", handler)
+	server.On("very-long-name", handler)
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	for i, arguments := range []string{
+		`["echo",1,2,3]`,
+		`["very-long-name"]`,
+		`["echo",[[[1]]]]`,
+	} {
+		reqID := i + 1
+		var msg Message
+		if err := json.Unmarshal([]byte(`{"a":`+arguments+`}`), &msg); err != nil {
+			t.Fatal(err)
+		}
+		msg.RequestID = &reqID
+		conn.send(msg)
+		resp := conn.waitWritten(t, time.Second)
+		errObj, _ := resp.ResponseError.(map[string]any)
+		errMsg, _ := errObj["message"].(string)
+		if !strings.HasPrefix(errMsg, ErrLimitExceeded.Error()) {
+			t.Fatalf("expected limit error for %s, got %+v", arguments, resp)
+		}
+	}
+
+	// Arguments within the limits are accepted
+	reqID := 4
+	conn.send(Message{RequestID: &reqID, Arguments: []json.RawMessage{
+		[]byte(`"echo"`), []byte(`[[1]]`), []byte(`"[[[["`),
+	}})
+	resp := conn.waitWritten(t, time.Second)
+	if resp.ResponseError != nil {
+		t.Fatalf("expected success, got %+v", resp)
+	}
+	if len(called) != 1 {
+		t.Fatalf("expected handler to be called once, got %d", len(called))
+	}
+}
+
+// TestLimitsClose verifies that the connection is closed with the appropriate
+// status code when an event or message exceeds the limits.
+func TestLimitsClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments []json.RawMessage
+		status    StatusCode
+	}{
+		{"event", []json.RawMessage{
+			[]byte(`"echo"`), []byte(`1`), []byte(`2`), []byte(`3`),
+		}, StatusPolicyViolation},
+		{"size", []json.RawMessage{
+			[]byte(`"echo"`), []byte(`"` + strings.Repeat("a", 100) + `"`),
+		}, StatusMessageTooBig},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetLimits(Limits{MaxMessageSize: 64, MaxArguments: 3})
+			server.On("echo", func(args ...any) error { return nil })
+			closeStatus := make(chan StatusCode, 1)
+			server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+				closeStatus <- status
+			})
+			conn := readLimitConn{newMockConn(), make(chan int64, 1)}
+			if err := server.Accept(conn); err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			if limit := <-conn.limit; limit != 64 {
+				t.Fatalf("expected read limit 64, got %d", limit)
+			}
+
+			conn.send(Message{Arguments: test.arguments})
+			select {
+			case status := <-closeStatus:
+				if status != test.status {
+					t.Fatalf("expected %v, got %v", test.status, status)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("client was not closed")
+			}
+		})
+	}
+}
+
+// TestClientLimits verifies that Client.SetLimits overrides the server's
+// limits and updates the read limit of the connection.
+func TestClientLimits(t *testing.T) {
+	server := NewServer()
+	server.SetLimits(Limits{MaxArguments: 1})
+	server.On("echo", func(args ...any) error { return nil })
+	conn := readLimitConn{newMockConn(), make(chan int64, 1)}
+	var client *Client
+	server.Once("open", func(c *Client) {
+		client = c
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client.SetLimits(Limits{MaxMessageSize: 1024})
+	if limit := <-conn.limit; limit != 1024 {
+		t.Fatalf("expected read limit 1024, got %d", limit)
+	}
+	reqID := 1
+	conn.send(Message{RequestID: &reqID, Arguments: []json.RawMessage{
+		[]byte(`"echo"`), []byte(`1`),
+	}})
+	resp := conn.waitWritten(t, time.Second)
+	if resp.ResponseError != nil {
+		t.Fatalf("expected success, got %+v", resp)
+	}
+}
@@ -27,6 +27,16 @@ func (bit *weakBool) UnmarshalJSON(data []byte) error {
 
 // Message is a ws-wrapper JSON-encoded message.
 // See https://github.com/bminer/ws-wrapper/blob/master/README.md#protocol
+//
+// Attachments hold the []byte arguments of a message that are sent as binary
+// WebSocket frames following the message rather than being base64-encoded (see
+// Server.SetBinaryAttachments). Each such argument is replaced by a placeholder
+// such as {"_placeholder":true,"num":0}, where num is the index of the
+// attachment. Only this exact encoding is a placeholder; other arguments of the
+// same shape are sent with their keys in a different order. The JSON encoding
+// of a message has a "b" key with the number of attachments. When decoding a
+// message, Attachments is set to a slice of that length, which a Conn fills by
+// reading the binary frames that follow.
 type Message struct {
 	Channel         string            `json:"c,omitempty"`
 	Arguments       []json.RawMessage `json:"a,omitempty"` // Arguments[0] is the event name
@@ -35,10 +45,83 @@ type Message struct {
 	ResponseError   any               `json:"e,omitempty"`
 	ResponseJSError weakBool          `json:"_,omitempty"`
 	CancelReason    any               `json:"x,omitempty"` // Request cancellation signal
+	StreamChunk     weakBool          `json:"s,omitempty"` // Response is one chunk of a stream
+	Progress        any               `json:"p,omitempty"` // Progress of a request
 	IgnoreIfFalse   *weakBool         `json:"ws-wrapper,omitempty"`
+	Version         string            `json:"v,omitempty"`    // Protocol version (hello message)
+	Capabilities    []string          `json:"caps,omitempty"` // Protocol extensions supported (hello message)
+	Session         string            `json:"sid,omitempty"`  // Session ID (session message)
+	Sequence        uint64            `json:"n,omitempty"`    // Event sequence number; see Server.SetSessions
+	Attachments     [][]byte          `json:"-"`              // Binary attachments; see below
+	rawResponseData json.RawMessage   // ResponseData as encoded by codec; see SetRawResponseData
+	codec           Codec             // codec of the connection the message was read from
+	frame           []byte            // message pre-encoded with frameCodec; see Encode
+	frameCodec      Codec
 	processed       chan struct{}
 }
 
+// Encode encodes the message as a WebSocket frame with codec. An event sent to
+// many clients (i.e. by ServerChannel.Emit) is encoded once for each codec, in
+// which case the pre-encoded frame is returned. Conn implementations should
+// call Encode rather than Codec.MarshalMessage, so that a broadcast is not
+// encoded again for every client.
+func (m *Message) Encode(codec Codec) ([]byte, error) {
+	if m.frame != nil && m.frameCodec.Name() == codec.Name() {
+		return m.frame, nil
+	}
+	return codec.MarshalMessage(m)
+}
+
+// MarshalJSON encodes the message as JSON, including the number of binary
+// attachments.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message // prevent recursion
+	if len(m.Attachments) == 0 {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		Attachments int `json:"b"`
+	}{message(m), len(m.Attachments)})
+}
+
+// UnmarshalJSON decodes a JSON-encoded message. The raw JSON of the response
+// data is retained, so responses can be decoded directly into a specific type
+// (see Call).
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message // prevent recursion
+	aux := struct {
+		*message
+		ResponseData json.RawMessage `json:"d,omitempty"`
+		Attachments  int             `json:"b,omitempty"`
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	// Every attachment replaces an argument
+	if aux.Attachments < 0 || aux.Attachments > len(m.Arguments) {
+		return fmt.Errorf("invalid number of attachments: %d", aux.Attachments)
+	}
+	m.Attachments = nil
+	if aux.Attachments > 0 {
+		m.Attachments = make([][]byte, aux.Attachments)
+	}
+	m.ResponseData = nil
+	m.SetRawResponseData(aux.ResponseData)
+	if aux.ResponseData != nil {
+		return json.Unmarshal(aux.ResponseData, &m.ResponseData)
+	}
+	return nil
+}
+
+// SetRawResponseData retains the response data of a decoded message as it was
+// encoded by the codec of the connection, so the response can be decoded
+// directly into a specific type (see Call) rather than encoded again from
+// ResponseData. Codecs call it from Codec.UnmarshalMessage.
+func (m *Message) SetRawResponseData(data []byte) {
+	m.rawResponseData = data
+}
+
 // EventName returns the name of the event or empty string if the message is
 // invalid
 func (m Message) EventName() string {
@@ -46,8 +129,12 @@ func (m Message) EventName() string {
 	if len(m.Arguments) < 1 {
 		return ""
 	}
+	codec := m.codec
+	if codec == nil {
+		codec = JSON
+	}
 	var name string
-	err := json.Unmarshal(m.Arguments[0], &name)
+	err := codec.Unmarshal(m.Arguments[0], &name)
 	if err != nil {
 		return ""
 	}
@@ -71,29 +158,9 @@ func (m Message) Response() (any, error) {
 		// No error
 		return m.ResponseData, nil
 	}
-	// Handle JavaScript error
-	if m.ResponseJSError {
-		jsErr, ok := m.ResponseError.(map[string]any)
-		if !ok {
-			return nil, errors.New(
-				"response is a malformed JavaScript error: not an object",
-			)
-		}
-		errMsg, ok := jsErr["message"].(string)
-		if !ok {
-			return nil, errors.New(
-				"response is a malformed JavaScript error: " +
-					"message key is not a string",
-			)
-		}
-		return nil, errors.New(errMsg)
-	}
-	// Handle string error
-	errMsg, ok := m.ResponseError.(string)
-	if !ok {
-		return nil, errors.New("response error is not a string")
-	}
-	return nil, errors.New(errMsg)
+	return nil, remoteError(
+		m.ResponseError, bool(m.ResponseJSError), "response",
+	)
 }
 
 // CancelCause returns the reason for this cancellation message as an error.
@@ -102,29 +169,39 @@ func (m Message) CancelCause() error {
 	if m.RequestID == nil || m.CancelReason == nil {
 		return errors.New("message is not a cancellation")
 	}
-	// Handle JavaScript error
-	if m.ResponseJSError {
-		jsErr, ok := m.CancelReason.(map[string]any)
+	// A reason that is not a string or JavaScript error is not a reason
+	if _, ok := m.CancelReason.(string); !ok && !bool(m.ResponseJSError) {
+		return context.Canceled
+	}
+	return remoteError(m.CancelReason, bool(m.ResponseJSError), "cancel reason")
+}
+
+// remoteError converts an error received from the remote end into a
+// *RemoteError. If jsError is true, v must be a JavaScript error object;
+// otherwise, v must be a string. what describes v in the returned error if v
+// is malformed.
+func remoteError(v any, jsError bool, what string) error {
+	if jsError {
+		jsErr, ok := v.(map[string]any)
 		if !ok {
 			return errors.New(
-				"cancel reason is a malformed JavaScript error: not an object",
+				what + " is a malformed JavaScript error: not an object",
 			)
 		}
 		errMsg, ok := jsErr["message"].(string)
 		if !ok {
 			return errors.New(
-				"cancel reason is a malformed JavaScript error: " +
+				what + " is a malformed JavaScript error: " +
 					"message key is not a string",
 			)
 		}
-		return errors.New(errMsg)
+		return &RemoteError{Message: errMsg, JSError: true, Object: jsErr}
 	}
-	// Handle string error
-	errMsg, ok := m.CancelReason.(string)
+	errMsg, ok := v.(string)
 	if !ok {
-		return context.Canceled
+		return errors.New(what + " error is not a string")
 	}
-	return errors.New(errMsg)
+	return &RemoteError{Message: errMsg}
 }
 
 func (m Message) LogValue() slog.Value {
@@ -164,6 +241,11 @@ func (m Message) LogValue() slog.Value {
 				slog.Any("cancelReason", m.CancelReason),
 				slog.Bool("jsError", bool(m.ResponseJSError)),
 			}
+		} else if m.Progress != nil {
+			attrs = []slog.Attr{
+				slog.Int("reqID", *m.RequestID),
+				slog.Any("progress", m.Progress),
+			}
 		} else if m.ResponseError != nil {
 			attrs = []slog.Attr{
 				slog.Int("reqID", *m.RequestID),
@@ -192,5 +274,8 @@ func (m Message) Processed() <-chan struct{} {
 // messageResponse is a response to a message
 type messageResponse struct {
 	Data  any
+	Raw   json.RawMessage // encoded Data as received, if available
+	Codec Codec           // codec used to decode Data
 	Error error
+	More  bool // Data is a stream chunk; more responses follow
 }
@@ -3,6 +3,8 @@ package wrapper
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -142,6 +144,42 @@ func TestMessageResponse(t *testing.T) {
 		}
 	})
 
+	t.Run("JavaScript error details", func(t *testing.T) {
+		jsErr := map[string]any{
+			"message": "not found",
+			"name":    "NotFoundError",
+			"code":    "ENOENT",
+			"stack":   "NotFoundError: not found\n    at <anonymous>",
+		}
+		msg := Message{
+			RequestID:       &reqID,
+			ResponseError:   jsErr,
+			ResponseJSError: true,
+		}
+		_, err := msg.Response()
+		var remoteErr *RemoteError
+		if !errors.As(err, &remoteErr) {
+			t.Fatalf("expected *RemoteError, got %T", err)
+		}
+		if !remoteErr.JSError || !reflect.DeepEqual(remoteErr.Object, jsErr) {
+			t.Errorf("unexpected remote error %+v", remoteErr)
+		}
+		if remoteErr.Name() != "NotFoundError" || remoteErr.Code() != "ENOENT" {
+			t.Errorf(
+				"unexpected name %q or code %v", remoteErr.Name(), remoteErr.Code(),
+			)
+		}
+
+		msg = Message{RequestID: &reqID, ResponseError: "plain"}
+		_, err = msg.Response()
+		if !errors.As(err, &remoteErr) {
+			t.Fatalf("expected *RemoteError, got %T", err)
+		}
+		if remoteErr.JSError || remoteErr.Object != nil || remoteErr.Name() != "" {
+			t.Errorf("unexpected remote error %+v", remoteErr)
+		}
+	})
+
 	t.Run("missing request ID", func(t *testing.T) {
 		msg := Message{}
 		_, err := msg.Response()
@@ -222,6 +260,21 @@ func TestMessageCancelCause(t *testing.T) {
 		}
 	})
 
+	t.Run("JavaScript error cancel reason details", func(t *testing.T) {
+		msg := Message{
+			RequestID:       &reqID,
+			CancelReason:    map[string]any{"message": "timeout", "code": 408.0},
+			ResponseJSError: true,
+		}
+		var remoteErr *RemoteError
+		if err := msg.CancelCause(); !errors.As(err, &remoteErr) {
+			t.Fatalf("expected *RemoteError, got %T", err)
+		}
+		if remoteErr.Code() != 408.0 {
+			t.Errorf("expected code 408, got %v", remoteErr.Code())
+		}
+	})
+
 	t.Run("malformed JavaScript error", func(t *testing.T) {
 		msg := Message{
 			RequestID:       &reqID,
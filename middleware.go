@@ -0,0 +1,49 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// HandlerCall describes an inbound event or request that is about to be
+// passed to an event handler. Middleware may change Channel, Event, Arguments,
+// and Attachments before calling the next function; the event handler is
+// looked up with the Channel and Event passed to the end of the chain. Client
+// and RequestID are read-only; changing them has no effect.
+type HandlerCall struct {
+	Client      *Client           // the client that sent the event
+	Channel     string            // channel name; empty for the main channel
+	Event       string            // event name
+	RequestID   *int              // request ID; nil if the event is not a request
+	Arguments   []json.RawMessage // encoded handler arguments (see Client.Codec)
+	Attachments [][]byte          // binary attachments (see Message.Attachments)
+}
+
+// NextFunc calls the next middleware in the chain or, at the end of the chain,
+// the event handler. It returns the result and error of the handler. If there
+// is no event handler for the call, an error is returned.
+type NextFunc func(ctx context.Context, call HandlerCall) (any, error)
+
+// Middleware wraps the execution of event handlers. Middleware is called for
+// every inbound event and request, including those for which no event handler
+// exists. A middleware may run code before and after calling next, modify the
+// context or HandlerCall passed to next, change the result or error returned by
+// next, or reject the call by returning an error without calling next. For
+// requests, the returned result or error is sent to the client as the response.
+// See Server.Use and Client.Use.
+type Middleware func(
+	ctx context.Context, call HandlerCall, next NextFunc,
+) (any, error)
+
+// chainMiddleware returns a NextFunc that calls each middleware in order,
+// followed by handler.
+func chainMiddleware(middleware []Middleware, handler NextFunc) NextFunc {
+	next := handler
+	for i := len(middleware) - 1; i >= 0; i-- {
+		mw, inner := middleware[i], next
+		next = func(ctx context.Context, call HandlerCall) (any, error) {
+			return mw(ctx, call, inner)
+		}
+	}
+	return next
+}
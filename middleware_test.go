@@ -0,0 +1,165 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMiddlewareOrder verifies that server middleware runs before client
+// middleware, that both wrap the handler, and that middleware receives the
+// details of the call.
+func TestMiddlewareOrder(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+	var gotCall HandlerCall
+	server.Use(func(ctx context.Context, call HandlerCall, next NextFunc) (any, error) {
+		gotCall = call
+		record("server before")
+		res, err := next(ctx, call)
+		record("server after")
+		return res, err
+	})
+	server.On("open", func(c *Client) {
+		c.Use(func(ctx context.Context, call HandlerCall, next NextFunc) (any, error) {
+			record("client before")
+			res, err := next(ctx, call)
+			record("client after")
+			// Modify the result
+			return res.(string) + "!", err
+		})
+	})
+	server.Of("math").On("add", func(a, b int) (string, error) {
+		record("handler")
+		return "sum", nil
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusNormalClosure, "done")
+
+	reqID := 3
+	conn.send(Message{
+		Channel:   "math",
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{
+			[]byte(`"add"`), []byte(`1`), []byte(`2`),
+		},
+	})
+	resp := conn.waitWritten(t, time.Second)
+	if resp.ResponseData != "sum!" {
+		t.Fatalf("expected modified result 'sum!', got %+v", resp)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"server before", "client before", "handler", "client after",
+		"server after",
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected order %v, got %v", want, order)
+	}
+	if gotCall.Client == nil || gotCall.Channel != "math" ||
+		gotCall.Event != "add" || gotCall.RequestID == nil ||
+		*gotCall.RequestID != reqID || len(gotCall.Arguments) != 2 {
+		t.Fatalf("unexpected HandlerCall %+v", gotCall)
+	}
+}
+
+// TestMiddlewareReject verifies that middleware can reject a call without
+// invoking the handler.
+func TestMiddlewareReject(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	server.Use(func(ctx context.Context, call HandlerCall, next NextFunc) (any, error) {
+		if call.Client.Get("user") == nil {
+			return nil, errors.New("not logged in")
+		}
+		return next(ctx, call)
+	})
+	called := false
+	server.On("secret", func() (string, error) {
+		called = true
+		return "secret", nil
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusNormalClosure, "done")
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"secret"`)},
+	})
+	resp := conn.waitWritten(t, time.Second)
+	if exp := map[string]any{
+		"message": "not logged in",
+	}; !reflect.DeepEqual(exp, resp.ResponseError) {
+		t.Fatalf("expected %v, got %v", exp, resp.ResponseError)
+	}
+	if called {
+		t.Fatal("handler should not have been called")
+	}
+}
+
+// TestMiddlewareUnknownEvent verifies that middleware is called for requests
+// without a handler and that changing the event name of a call routes it to
+// another handler.
+func TestMiddlewareUnknownEvent(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	seen := make(chan string, 2)
+	server.Use(func(ctx context.Context, call HandlerCall, next NextFunc) (any, error) {
+		seen <- call.Event
+		if call.Event == "old" {
+			call.Event = "new"
+		}
+		return next(ctx, call)
+	})
+	server.On("new", func() (string, error) {
+		return "new", nil
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusNormalClosure, "done")
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"missing"`)},
+	})
+	resp := conn.waitWritten(t, time.Second)
+	if exp := map[string]any{
+		"message": "no event listener for 'missing'",
+	}; !reflect.DeepEqual(exp, resp.ResponseError) {
+		t.Fatalf("expected %v, got %v", exp, resp.ResponseError)
+	}
+	if event := <-seen; event != "missing" {
+		t.Fatalf("expected middleware to see 'missing', got %q", event)
+	}
+
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"old"`)},
+	})
+	if resp := conn.waitWritten(t, time.Second); resp.ResponseData != "new" {
+		t.Fatalf("expected response from 'new' handler, got %+v", resp)
+	}
+}
@@ -0,0 +1,213 @@
+package wrapper
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultPipeBuffer is the number of messages that can be in flight in each
+// direction of a pipe created by Pipe
+const DefaultPipeBuffer = 64
+
+// PipeOptions configures the connections created by PipeWith
+type PipeOptions struct {
+	// Codec encodes the messages sent through the pipe. If nil, JSON is used.
+	Codec Codec
+	// Latency delays the delivery of each message
+	Latency time.Duration
+	// Buffer is the number of messages that can be in flight in each
+	// direction before WriteMessage blocks. If zero, DefaultPipeBuffer is
+	// used.
+	Buffer int
+}
+
+// Pipe returns two connected in-memory connections. Messages written to one
+// connection are read from the other, so a Server and a Client, or any two
+// components using this package, can communicate in the same process without a
+// network, e.g. in tests:
+//
+//	serverConn, clientConn := wrapper.Pipe()
+//	server.Accept(serverConn)
+//	client := wrapper.NewClient(clientConn)
+//
+// Messages are encoded and decoded like they would be on a WebSocket, so the
+// two ends never share memory. When one end is closed with Close, the other
+// end reads the messages that were already written and then fails to read
+// with a CloseError holding the status code and reason. When one end is closed
+// with CloseNow, messages in flight are discarded and the other end fails to
+// read with io.ErrUnexpectedEOF. Operations on a closed end return
+// io.ErrClosedPipe.
+func Pipe() (Conn, Conn) {
+	return PipeWith(PipeOptions{})
+}
+
+// PipeWith is like Pipe, but the connections are configured by opts
+func PipeWith(opts PipeOptions) (Conn, Conn) {
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSON
+	}
+	size := opts.Buffer
+	if size <= 0 {
+		size = DefaultPipeBuffer
+	}
+	ab := make(chan pipeFrame, size) // frames written by a
+	ba := make(chan pipeFrame, size) // frames written by b
+	aEnd := &pipeEnd{done: make(chan struct{})}
+	bEnd := &pipeEnd{done: make(chan struct{})}
+	a := &pipeConn{
+		codec: codec, latency: opts.Latency,
+		in: ba, out: ab, local: aEnd, remote: bEnd,
+	}
+	b := &pipeConn{
+		codec: codec, latency: opts.Latency,
+		in: ab, out: ba, local: bEnd, remote: aEnd,
+	}
+	return a, b
+}
+
+// pipeFrame is an encoded message in flight
+type pipeFrame struct {
+	data        []byte
+	attachments [][]byte
+	deliverAt   time.Time
+}
+
+// pipeEnd holds the closed state of one end of a pipe
+type pipeEnd struct {
+	once sync.Once
+	done chan struct{} // closed when the end is closed
+	err  error         // returned to the other end; set before done is closed
+	now  bool          // messages in flight are discarded
+}
+
+// close closes the end. err is returned by the other end once it has read
+// the messages in flight, or immediately if now is true.
+func (e *pipeEnd) close(err error, now bool) {
+	e.once.Do(func() {
+		e.err, e.now = err, now
+		close(e.done)
+	})
+}
+
+// closed returns true if the end is closed
+func (e *pipeEnd) closed() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// pipeConn is one end of a pipe. It implements the CodecConn interface.
+type pipeConn struct {
+	codec   Codec
+	latency time.Duration
+	in      <-chan pipeFrame
+	out     chan<- pipeFrame
+	local   *pipeEnd
+	remote  *pipeEnd
+	pending *pipeFrame // frame read, but not delivered yet due to latency
+}
+
+// Codec returns the codec used to encode messages
+func (c *pipeConn) Codec() Codec {
+	return c.codec
+}
+
+// ReadMessage reads the next message written to the other end
+func (c *pipeConn) ReadMessage(ctx context.Context, msg *Message) error {
+	if c.local.closed() {
+		return io.ErrClosedPipe
+	}
+	if c.pending == nil {
+		var f pipeFrame
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.local.done:
+			return io.ErrClosedPipe
+		case f = <-c.in:
+		case <-c.remote.done:
+			if c.remote.now {
+				return c.remote.err
+			}
+			// Read messages written before the other end was closed
+			select {
+			case f = <-c.in:
+			default:
+				return c.remote.err
+			}
+		}
+		c.pending = &f
+	}
+
+	// Wait for the message to be delivered
+	if wait := time.Until(c.pending.deliverAt); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.local.done:
+			return io.ErrClosedPipe
+		case <-timer.C:
+		}
+	}
+	if c.remote.now && c.remote.closed() {
+		return c.remote.err
+	}
+	f := c.pending
+	c.pending = nil
+	if err := c.codec.UnmarshalMessage(f.data, msg); err != nil {
+		return err
+	}
+	copy(msg.Attachments, f.attachments)
+	return nil
+}
+
+// WriteMessage writes a message to be read by the other end. It blocks if the
+// pipe's buffer is full.
+func (c *pipeConn) WriteMessage(ctx context.Context, msg *Message) error {
+	if c.local.closed() {
+		return io.ErrClosedPipe
+	} else if c.remote.closed() {
+		return c.remote.err
+	}
+	data, err := msg.Encode(c.codec)
+	if err != nil {
+		return err
+	}
+	f := pipeFrame{data: data, deliverAt: time.Now().Add(c.latency)}
+	for _, attachment := range msg.Attachments {
+		f.attachments = append(f.attachments, slices.Clone(attachment))
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.local.done:
+		return io.ErrClosedPipe
+	case <-c.remote.done:
+		return c.remote.err
+	case c.out <- f:
+		return nil
+	}
+}
+
+// Close closes the connection. The other end reads the messages in flight and
+// then fails to read with a CloseError.
+func (c *pipeConn) Close(statusCode StatusCode, reason string) error {
+	c.local.close(CloseError{Code: statusCode, Reason: reason}, false)
+	return nil
+}
+
+// CloseNow closes the connection immediately. Messages in flight are discarded
+// and the other end fails to read with io.ErrUnexpectedEOF.
+func (c *pipeConn) CloseNow() error {
+	c.local.close(io.ErrUnexpectedEOF, true)
+	return nil
+}
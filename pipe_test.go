@@ -0,0 +1,141 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// rawEventName returns the arguments of an event without arguments
+func rawEventName(name string) []json.RawMessage {
+	return []json.RawMessage{[]byte(`"` + name + `"`)}
+}
+
+// TestPipe verifies that a Server and a Client can communicate through a pipe
+func TestPipe(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.On("echo", func(s string) (string, error) {
+		return s, nil
+	})
+	server.On("size", func(b []byte) (int, error) {
+		return len(b), nil
+	})
+	server.SetBinaryAttachments(true)
+
+	serverConn, clientConn := Pipe()
+	if err := server.Accept(serverConn); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(clientConn)
+	defer client.Close(StatusNormalClosure, "")
+	client.SetBinaryAttachments(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data, err := client.Request(ctx, "echo", "hello")
+	if err != nil || data != "hello" {
+		t.Fatalf("expected echo, got %v (%v)", data, err)
+	}
+	size, err := Call[int](ctx, client, "size", []byte("abc"))
+	if err != nil || size != 3 {
+		t.Fatalf("expected size 3, got %v (%v)", size, err)
+	}
+}
+
+// TestPipeClose verifies that the status code and reason of a close are
+// passed to the other end after the messages in flight are read.
+func TestPipeClose(t *testing.T) {
+	a, b := Pipe()
+	ctx := context.Background()
+	for range 2 {
+		if err := a.WriteMessage(ctx, &Message{Arguments: rawEventName("news")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close(StatusNormalClosure, "bye")
+	if err := a.WriteMessage(ctx, &Message{}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected io.ErrClosedPipe, got %v", err)
+	}
+	var msg Message
+	for range 2 {
+		if err := b.ReadMessage(ctx, &msg); err != nil || msg.EventName() != "news" {
+			t.Fatalf("expected message in flight, got %+v (%v)", msg, err)
+		}
+	}
+	err := b.ReadMessage(ctx, &msg)
+	var closeErr CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != StatusNormalClosure ||
+		closeErr.Reason != "bye" {
+		t.Fatalf("expected CloseError, got %v", err)
+	}
+
+	// The close status is passed to the Client on the other end
+	server := NewServer()
+	defer server.Close()
+	closed := make(chan StatusCode, 1)
+	server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+		closed <- status
+	})
+	serverConn, clientConn := Pipe()
+	server.Accept(serverConn)
+	client := NewClient(clientConn)
+	client.Close(StatusPolicyViolation, "go away")
+	select {
+	case status := <-closed:
+		if status != StatusPolicyViolation {
+			t.Fatalf("expected StatusPolicyViolation, got %d", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server client was not closed")
+	}
+}
+
+// TestPipeCloseNow verifies that messages in flight are discarded when a pipe
+// is closed with CloseNow.
+func TestPipeCloseNow(t *testing.T) {
+	a, b := Pipe()
+	ctx := context.Background()
+	if err := a.WriteMessage(ctx, &Message{Arguments: rawEventName("news")}); err != nil {
+		t.Fatal(err)
+	}
+	a.CloseNow()
+	var msg Message
+	if err := b.ReadMessage(ctx, &msg); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if err := b.WriteMessage(ctx, &Message{}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// TestPipeLatency verifies that messages are delayed by the latency and that
+// a read can be cancelled without losing the message.
+func TestPipeLatency(t *testing.T) {
+	a, b := PipeWith(PipeOptions{Latency: 50 * time.Millisecond, Buffer: 1})
+	start := time.Now()
+	if err := a.WriteMessage(context.Background(), &Message{Arguments: rawEventName("news")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer is full
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := a.WriteMessage(ctx, &Message{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected write to block, got %v", err)
+	}
+
+	var msg Message
+	if err := b.ReadMessage(ctx, &msg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected cancelled read, got %v", err)
+	}
+	if err := b.ReadMessage(context.Background(), &msg); err != nil || msg.EventName() != "news" {
+		t.Fatalf("expected message, got %+v (%v)", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected message to be delayed, got %v", elapsed)
+	}
+}
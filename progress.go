@@ -0,0 +1,127 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// progressBufferSize is the number of progress notifications buffered for a
+// progress callback that has not handled the previous ones yet. Once the
+// buffer is full, the oldest notification is discarded because it is
+// superseded by the later ones.
+const progressBufferSize = 16
+
+// errProgressUnsupported is returned by ReportProgress if the remote end does
+// not support progress notifications
+var errProgressUnsupported = errors.New(
+	"remote end does not support progress notifications",
+)
+
+// ReportProgress sends a progress notification for the request being handled
+// to the remote end. ctx must be the context passed to a request handler (or
+// derived from it), and value may be any JSON-encodable value, such as a
+// percentage or a status object. The remote end receives the value in the
+// progress callback passed to ClientChannel.RequestWithProgress; it is ignored
+// if the request was sent with ClientChannel.Request.
+//
+// Progress notifications are an extension of the ws-wrapper protocol, so they
+// are only sent if the remote end announced CapabilityProgress in its hello
+// message. Returns an error if the remote end did not announce it, if ctx is
+// not the context of a request handler, or if the request has already
+// completed or been cancelled.
+func ReportProgress(ctx context.Context, value any) error {
+	c := ClientFromContext(ctx)
+	requestID := requestIDFromContext(ctx)
+	if c == nil || requestID == nil {
+		return errors.New("context is not a request handler context")
+	}
+	if !c.Supports(CapabilityProgress) {
+		return errProgressUnsupported
+	}
+	// The request is in progress as long as it can be cancelled
+	c.inboundCancelsMu.Lock()
+	_, ok := c.inboundCancels[*requestID]
+	c.inboundCancelsMu.Unlock()
+	if !ok {
+		return errors.New("request is no longer in progress")
+	}
+	return c.writeMessage(ctx, &Message{
+		RequestID: requestID,
+		Progress:  value,
+	})
+}
+
+// progressDispatcher passes the progress notifications received for a request
+// sent by ClientChannel.RequestWithProgress to its progress callback. The
+// callback is called by a dedicated goroutine, so the goroutine reading
+// messages from the connection never waits for it.
+type progressDispatcher struct {
+	callback func(any)
+	mu       sync.Mutex // protects the fields below
+	values   []any      // notifications not yet passed to callback
+	closed   bool
+	wake     chan struct{} // signals the goroutine that a field changed
+	done     chan struct{} // closed when the goroutine exits
+}
+
+// newProgressDispatcher creates a progressDispatcher and starts its goroutine
+func newProgressDispatcher(callback func(any)) *progressDispatcher {
+	p := &progressDispatcher{
+		callback: callback,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// run passes notifications to the callback until the dispatcher is closed
+func (p *progressDispatcher) run() {
+	defer close(p.done)
+	for {
+		p.mu.Lock()
+		values, closed := p.values, p.closed
+		p.values = nil
+		p.mu.Unlock()
+		if len(values) == 0 {
+			if closed {
+				return
+			}
+			<-p.wake
+			continue
+		}
+		for _, value := range values {
+			p.callback(value)
+		}
+	}
+}
+
+// signal wakes up the goroutine unless it is already awake
+func (p *progressDispatcher) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// post queues a notification for the callback without waiting for it
+func (p *progressDispatcher) post(value any) {
+	p.mu.Lock()
+	if len(p.values) == progressBufferSize {
+		p.values = p.values[1:]
+	}
+	p.values = append(p.values, value)
+	p.mu.Unlock()
+	p.signal()
+}
+
+// close waits until the callback has been called with every queued
+// notification and stops the goroutine
+func (p *progressDispatcher) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.signal()
+	<-p.done
+}
@@ -0,0 +1,159 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestReportProgress verifies that handlers can send progress notifications
+// for the request they are handling.
+func TestReportProgress(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	server.On("export", func(ctx context.Context) (string, error) {
+		for _, pct := range []int{50, 100} {
+			if err := ReportProgress(ctx, pct); err != nil {
+				return "", err
+			}
+		}
+		return "done", nil
+	})
+	server.On("event", func(ctx context.Context) error {
+		if err := ReportProgress(ctx, 1); err == nil {
+			t.Error("expected error reporting progress for an event")
+		}
+		return nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	// Progress is not reported to remote ends that do not announce support
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"export"`)},
+	})
+	msg := conn.waitWritten(t, time.Second)
+	exp := map[string]any{"message": errProgressUnsupported.Error()}
+	if msg.Progress != nil || !reflect.DeepEqual(exp, msg.ResponseError) {
+		t.Fatalf("expected unsupported progress error, got %+v", msg)
+	}
+
+	announce(t, conn, CapabilityProgress)
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"export"`)},
+	})
+	for _, pct := range []int{50, 100} {
+		msg := conn.waitWritten(t, time.Second)
+		if msg.RequestID == nil || *msg.RequestID != reqID || msg.Progress != pct {
+			t.Fatalf("expected progress %d, got %+v", pct, msg)
+		}
+	}
+	if msg := conn.waitWritten(t, time.Second); msg.ResponseData != "done" {
+		t.Fatalf("expected response, got %+v", msg)
+	}
+
+	conn.send(Message{Arguments: []json.RawMessage{[]byte(`"event"`)}})
+	if err := ReportProgress(context.Background(), 1); err == nil {
+		t.Fatal("expected error reporting progress without a request")
+	}
+}
+
+// TestRequestWithProgress verifies that progress notifications are passed to
+// the progress callback before the response is returned.
+func TestRequestWithProgress(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	var progress []any
+	done := make(chan any, 1)
+	go func() {
+		res, err := client.RequestWithProgress(context.Background(),
+			func(v any) {
+				progress = append(progress, v)
+			}, "export",
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	req := conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, Progress: 0.5})
+	conn.send(Message{RequestID: req.RequestID, Progress: 1.0})
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "done"})
+	if res := <-done; res != "done" {
+		t.Fatalf("expected response 'done', got %v", res)
+	}
+	if len(progress) != 2 || progress[0] != 0.5 || progress[1] != 1.0 {
+		t.Fatalf("unexpected progress %v", progress)
+	}
+}
+
+// TestRequestWithProgressSlowCallback verifies that a blocked progress
+// callback does not stop other messages from being read, and that
+// RequestWithProgress returns after the callback has handled all
+// notifications.
+func TestRequestWithProgressSlowCallback(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	release := make(chan struct{})
+	var progress []any
+	done := make(chan any, 1)
+	go func() {
+		res, err := client.RequestWithProgress(context.Background(),
+			func(v any) {
+				<-release
+				progress = append(progress, v)
+			}, "export",
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	req := conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, Progress: 0.5})
+	conn.send(Message{RequestID: req.RequestID, Progress: 1.0})
+
+	// Another request completes while the callback is blocked
+	other := make(chan any, 1)
+	go func() {
+		res, _ := client.Request(context.Background(), "ping")
+		other <- res
+	}()
+	req2 := conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req2.RequestID, ResponseData: "pong"})
+	select {
+	case res := <-other:
+		if res != "pong" {
+			t.Fatalf("expected response 'pong', got %v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked progress callback stopped reading messages")
+	}
+
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "done"})
+	select {
+	case res := <-done:
+		t.Fatalf("returned %v before the progress callback", res)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if res := <-done; res != "done" {
+		t.Fatalf("expected response 'done', got %v", res)
+	}
+	if len(progress) != 2 || progress[0] != 0.5 || progress[1] != 1.0 {
+		t.Fatalf("unexpected progress %v", progress)
+	}
+}
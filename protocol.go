@@ -0,0 +1,190 @@
+package wrapper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is a version of the ws-wrapper protocol
+type ProtocolVersion struct {
+	Major int
+	Minor int
+}
+
+var (
+	// ProtocolV3 is version 3 of the ws-wrapper protocol, which does not
+	// support request cancellation
+	ProtocolV3 = ProtocolVersion{Major: 3}
+	// ProtocolV4 is version 4 of the ws-wrapper protocol, which added request
+	// cancellation
+	ProtocolV4 = ProtocolVersion{Major: 4}
+	// Protocol is the version of the ws-wrapper protocol implemented by this
+	// library
+	Protocol = ProtocolVersion{Major: 4, Minor: 1}
+)
+
+// Capability is an extension of the ws-wrapper protocol implemented by this
+// library. Other ws-wrapper implementations would misinterpret the messages of
+// an extension, so it is only used if the remote end announced it in its hello
+// message (see Server.SetHandshake and Client.Supports).
+type Capability string
+
+const (
+	// CapabilityStream is the streaming of responses in chunks (see
+	// ClientChannel.RequestStream)
+	CapabilityStream Capability = "stream"
+	// CapabilityProgress is the notification of the progress of a request
+	// (see ReportProgress)
+	CapabilityProgress Capability = "progress"
+)
+
+// capabilities are the capabilities announced in the hello message
+var capabilities = []Capability{CapabilityStream, CapabilityProgress}
+
+// ParseProtocolVersion parses a version such as "4.1" or "4"
+func ParseProtocolVersion(s string) (ProtocolVersion, error) {
+	majorStr, minorStr, hasMinor := strings.Cut(s, ".")
+	major, err := strconv.Atoi(majorStr)
+	if err != nil || major < 0 {
+		return ProtocolVersion{}, fmt.Errorf("invalid protocol version %q", s)
+	}
+	minor := 0
+	if hasMinor {
+		minor, err = strconv.Atoi(minorStr)
+		if err != nil || minor < 0 {
+			return ProtocolVersion{}, fmt.Errorf("invalid protocol version %q", s)
+		}
+	}
+	return ProtocolVersion{Major: major, Minor: minor}, nil
+}
+
+// String returns the version formatted as "major.minor"
+func (v ProtocolVersion) String() string {
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+}
+
+// AtLeast returns true if v is the same as or newer than other
+func (v ProtocolVersion) AtLeast(other ProtocolVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	return v.Minor >= other.Minor
+}
+
+// SetHandshake sets whether the Client announces its protocol version to the
+// remote end when a connection is bound, overriding the setting of its Server
+// (see Server.SetHandshake). For clients accepted by a Server, call
+// SetHandshake from the server's "open" handler.
+func (c *Client) SetHandshake(enabled bool) {
+	c.connReqMu.Lock()
+	c.handshakeConf = &enabled
+	c.connReqMu.Unlock()
+}
+
+// SetCompatibility sets the protocol version assumed for the remote end if it
+// does not announce its version, overriding the setting of its Server (see
+// Server.SetCompatibility).
+func (c *Client) SetCompatibility(version ProtocolVersion) {
+	c.connReqMu.Lock()
+	c.compatVersion = &version
+	c.connReqMu.Unlock()
+}
+
+// ProtocolVersion returns the protocol version of the remote end. It is the
+// version announced by the remote end or, if it has not announced one, the
+// version set by SetCompatibility (Protocol by default). If a remote end that
+// has not announced its version uses a feature of a newer version than the one
+// assumed, such as request cancellation, the newer version is returned.
+func (c *Client) ProtocolVersion() ProtocolVersion {
+	c.connReqMu.Lock()
+	peer, compat := c.peerVersion, c.compatVersion
+	c.connReqMu.Unlock()
+	if peer != nil {
+		return *peer
+	} else if compat != nil {
+		return *compat
+	} else if c.server != nil {
+		return c.server.compatibility()
+	}
+	return Protocol
+}
+
+// Supports returns true if the remote end announced that it supports the
+// capability in its hello message. Remote ends that did not send a hello
+// message, such as ws-wrapper JavaScript clients, support no capabilities.
+func (c *Client) Supports(capability Capability) bool {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	return slices.Contains(c.peerCaps, capability)
+}
+
+// handshake returns true if the Client announces its protocol version
+func (c *Client) handshake() bool {
+	c.connReqMu.Lock()
+	enabled := c.handshakeConf
+	c.connReqMu.Unlock()
+	if enabled != nil {
+		return *enabled
+	}
+	return c.server != nil && c.server.handshake()
+}
+
+// sendHello announces the protocol version to the remote end, unless it was
+// already announced on the active connection. The hello message is ignored by
+// ws-wrapper implementations that do not support it.
+func (c *Client) sendHello(ctx context.Context) error {
+	c.connReqMu.Lock()
+	sent := c.helloSent
+	c.helloSent = true
+	c.connReqMu.Unlock()
+	if sent {
+		return nil
+	}
+	ignore := weakBool(false)
+	caps := make([]string, len(capabilities))
+	for i, capability := range capabilities {
+		caps[i] = string(capability)
+	}
+	return c.writeMessage(ctx, &Message{
+		IgnoreIfFalse: &ignore,
+		Version:       Protocol.String(),
+		Capabilities:  caps,
+	})
+}
+
+// handleHello records the protocol version and capabilities announced by the
+// remote end and replies with our own version
+func (c *Client) handleHello(
+	ctx context.Context, version string, caps []string,
+) error {
+	v, err := ParseProtocolVersion(version)
+	if err != nil {
+		return err
+	}
+	peerCaps := make([]Capability, len(caps))
+	for i, capability := range caps {
+		peerCaps[i] = Capability(capability)
+	}
+	c.connReqMu.Lock()
+	c.peerVersion = &v
+	c.peerCaps = peerCaps
+	c.connReqMu.Unlock()
+	return c.sendHello(ctx)
+}
+
+// detectVersion records that the remote end uses a feature of the given
+// protocol version. It has no effect if the remote end announced its version
+// or a newer version is already assumed.
+func (c *Client) detectVersion(version ProtocolVersion) {
+	if c.ProtocolVersion().AtLeast(version) {
+		return
+	}
+	c.connReqMu.Lock()
+	if c.peerVersion == nil {
+		c.peerVersion = &version
+	}
+	c.connReqMu.Unlock()
+}
@@ -0,0 +1,162 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestParseProtocolVersion verifies parsing and comparing protocol versions
+func TestParseProtocolVersion(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected ProtocolVersion
+		valid    bool
+	}{
+		{"4.1", ProtocolVersion{4, 1}, true},
+		{"3", ProtocolV3, true},
+		{"10.0", ProtocolVersion{10, 0}, true},
+		{"", ProtocolVersion{}, false},
+		{"4.x", ProtocolVersion{}, false},
+		{"-1", ProtocolVersion{}, false},
+	}
+	for _, test := range tests {
+		v, err := ParseProtocolVersion(test.s)
+		if (err == nil) != test.valid || v != test.expected {
+			t.Errorf("ParseProtocolVersion(%q) = %v, %v", test.s, v, err)
+		}
+	}
+	if !Protocol.AtLeast(ProtocolV4) || ProtocolV3.AtLeast(ProtocolV4) {
+		t.Error("unexpected version ordering")
+	}
+	if !(ProtocolVersion{10, 0}).AtLeast(ProtocolVersion{9, 5}) {
+		t.Error("expected 10.0 to be newer than 9.5")
+	}
+}
+
+// TestHandshake verifies that a hello message is sent when the handshake is
+// enabled and that a hello message from the remote end is recorded and
+// answered.
+func TestHandshake(t *testing.T) {
+	server := NewServer()
+	server.SetHandshake(true)
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	hello := conn.waitWritten(t, time.Second)
+	if hello.IgnoreIfFalse == nil || *hello.IgnoreIfFalse ||
+		hello.Version != Protocol.String() {
+		t.Fatalf("expected hello message, got %+v", hello)
+	}
+	data, err := json.Marshal(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"ws-wrapper":false,"v":"4.1","caps":["stream","progress"]}` {
+		t.Fatalf("unexpected hello message %s", data)
+	}
+
+	var msg Message
+	if err := json.Unmarshal([]byte(`{"ws-wrapper":false,"v":"3.2"}`), &msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.send(msg)
+	deadline := time.Now().Add(time.Second)
+	for client.ProtocolVersion() != (ProtocolVersion{3, 2}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected version 3.2, got %v", client.ProtocolVersion())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case msg := <-conn.writeCh:
+		t.Fatalf("expected no reply to hello, got %+v", msg)
+	default:
+	}
+
+	// The remote end does not support cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-conn.writeCh // request
+		cancel()
+	}()
+	if _, err := client.Request(ctx, "slow"); err == nil {
+		t.Fatal("expected cancelled request")
+	}
+	select {
+	case msg := <-conn.writeCh:
+		t.Fatalf("expected no cancellation, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestHandshakeReply verifies that a hello message is answered if the
+// handshake is not enabled locally.
+func TestHandshakeReply(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	ignore := weakBool(false)
+	conn.send(Message{IgnoreIfFalse: &ignore, Version: "5.0"})
+	hello := conn.waitWritten(t, time.Second)
+	if hello.Version != Protocol.String() {
+		t.Fatalf("expected hello reply, got %+v", hello)
+	}
+	if client.ProtocolVersion() != (ProtocolVersion{5, 0}) {
+		t.Fatalf("expected version 5.0, got %v", client.ProtocolVersion())
+	}
+}
+
+// TestCompatibility verifies that cancellations are not sent to clients
+// assumed to use ws-wrapper v3 until they use cancellation themselves.
+func TestCompatibility(t *testing.T) {
+	server := NewServer()
+	server.SetCompatibility(ProtocolV3)
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+	if client.ProtocolVersion() != ProtocolV3 {
+		t.Fatalf("expected version 3.0, got %v", client.ProtocolVersion())
+	}
+
+	// Request is cancelled without sending a cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-conn.writeCh // request
+		cancel()
+	}()
+	if _, err := client.Request(ctx, "slow"); err == nil {
+		t.Fatal("expected cancelled request")
+	}
+	select {
+	case msg := <-conn.writeCh:
+		t.Fatalf("expected no cancellation, got %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The client cancels a request, so it supports cancellation
+	reqID := 1
+	conn.send(Message{RequestID: &reqID, CancelReason: "stop"})
+	deadline := time.Now().Add(time.Second)
+	for client.ProtocolVersion() != ProtocolV4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected version 4.0, got %v", client.ProtocolVersion())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		<-conn.writeCh // request
+		cancel()
+	}()
+	client.Request(ctx, "slow")
+	msg := conn.waitWritten(t, time.Second)
+	if msg.CancelReason == nil {
+		t.Fatalf("expected cancellation, got %+v", msg)
+	}
+}
@@ -0,0 +1,208 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// Room is a named group of clients connected to a Server. Events emitted and
+// requests sent on a Room are delivered only to its members. Like channels,
+// Room values are lightweight handles; the set of members is stored on the
+// Server, so any number of Room values with the same name refer to the same
+// group of clients.
+//
+// Clients leave all rooms automatically when they disconnect.
+type Room struct {
+	name   string
+	server *Server
+}
+
+// Name returns the name of the room
+func (r Room) Name() string {
+	return r.name
+}
+
+// Join adds the client to the room and calls the Server's OnRoomJoin hook.
+// Joining a room that the client has already joined does nothing. Returns an
+// error if the client is not connected to the room's Server.
+func (r Room) Join(c *Client) error {
+	s := r.server
+	// Hold clientsMu while joining, so the client cannot be closed
+	// concurrently; Client.close removes the client from s.clients before
+	// leaving all rooms.
+	s.clientsMu.Lock()
+	if _, ok := s.clients[c]; !ok {
+		s.clientsMu.Unlock()
+		return errors.New("client is not connected to the server")
+	}
+	s.roomsMu.Lock()
+	members := s.rooms[r.name]
+	_, joined := members[c]
+	if !joined {
+		if members == nil {
+			members = make(map[*Client]struct{})
+			s.rooms[r.name] = members
+		}
+		members[c] = struct{}{}
+		if c.rooms == nil {
+			c.rooms = make(map[string]struct{})
+		}
+		c.rooms[r.name] = struct{}{}
+	}
+	s.roomsMu.Unlock()
+	s.clientsMu.Unlock()
+
+	if !joined {
+		s.emitRoom(true, r, c)
+	}
+	return nil
+}
+
+// Leave removes the client from the room and calls the Server's OnRoomLeave
+// hook. Leaving a room that the client has not joined does nothing.
+func (r Room) Leave(c *Client) {
+	s := r.server
+	s.roomsMu.Lock()
+	left := s.leaveRoom(r.name, c)
+	s.roomsMu.Unlock()
+
+	if left {
+		s.emitRoom(false, r, c)
+	}
+}
+
+// Members returns the clients that have joined the room
+func (r Room) Members() []*Client {
+	s := r.server
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	members := make([]*Client, 0, len(s.rooms[r.name]))
+	for c := range s.rooms[r.name] {
+		members = append(members, c)
+	}
+	return members
+}
+
+// Emit sends an event to all members of the room on the main channel. The
+// passed context can be used to cancel writing the message to the clients.
+// The second argument is the event name that tells the remote end which event
+// handler to call. Returns a slice of ClientErrors that contains an entry if
+// an error occurred when sending the message to a specific client.
+func (r Room) Emit(ctx context.Context, arguments ...any) (errs []ClientError) {
+	return r.emit(ctx, nil, arguments)
+}
+
+// Broadcast sends an event to all members of the room except the client that
+// emitted the event currently being handled and any clients in exclude. See
+// ServerChannel.Broadcast for more information.
+func (r Room) Broadcast(
+	ctx context.Context, exclude []*Client, arguments ...any,
+) (errs []ClientError) {
+	return r.emit(ctx, excludeFilter(ctx, exclude), arguments)
+}
+
+// emit sends an event to all members of the room that are selected by filter.
+// If filter is nil, the event is sent to all members.
+func (r Room) emit(
+	ctx context.Context, filter func(*Client) bool, arguments []any,
+) (errs []ClientError) {
+	// Events are buffered for disconnected members with a session
+	members := r.server.withDetached(r.Members(), func(sess *session) bool {
+		return slices.Contains(sess.rooms, r.name)
+	})
+	return emitEvent(
+		ctx, members, filter, "", arguments,
+		r.server.broadcastConcurrency(),
+	)
+}
+
+// Request sends a request to all members of the room on the main channel and
+// waits for all of them to respond. The passed context can be used to cancel
+// the request. The second argument is the event name that tells the remote
+// end which request handler to call. Returns one ClientResponse per member.
+func (r Room) Request(
+	ctx context.Context, arguments ...any,
+) []ClientResponse {
+	responses, err := r.RequestWith(ctx, RequestOptions{}, arguments...)
+	if err != nil {
+		return []ClientResponse{{Client: nil, Error: err}}
+	}
+	return responses
+}
+
+// RequestWith sends a request to the members of the room selected by opts and
+// collects their responses. See ServerChannel.RequestWith for more
+// information.
+func (r Room) RequestWith(
+	ctx context.Context, opts RequestOptions, arguments ...any,
+) ([]ClientResponse, error) {
+	if err := checkEmitArguments("", arguments); err != nil {
+		return nil, err
+	}
+	return requestClients(ctx, r.Members(), "", opts, arguments)
+}
+
+// leaveRoom removes c from the named room. Returns true if c was a member.
+// s.roomsMu must be held by the caller.
+func (s *Server) leaveRoom(name string, c *Client) bool {
+	members := s.rooms[name]
+	if _, ok := members[c]; !ok {
+		return false
+	}
+	delete(members, c)
+	if len(members) == 0 {
+		delete(s.rooms, name)
+	}
+	delete(c.rooms, name)
+	return true
+}
+
+// leaveRooms removes c from all rooms it has joined and calls the OnRoomLeave
+// hook for each.
+func (s *Server) leaveRooms(c *Client) {
+	s.roomsMu.Lock()
+	names := make([]string, 0, len(c.rooms))
+	for name := range c.rooms {
+		if s.leaveRoom(name, c) {
+			names = append(names, name)
+		}
+	}
+	s.roomsMu.Unlock()
+
+	for _, name := range names {
+		s.emitRoom(false, Room{name: name, server: s}, c)
+	}
+}
+
+// OnRoomJoin sets a function that is called when a client joins a Room. The
+// hook is called synchronously by Room.Join after the client has been added to
+// the room. If f is nil, the hook is removed.
+func (s *Server) OnRoomJoin(f RoomHandler) {
+	s.handlersMu.Lock()
+	s.roomJoinHook = f
+	s.handlersMu.Unlock()
+}
+
+// OnRoomLeave sets a function that is called when a client leaves a Room,
+// including when it leaves all rooms because it disconnected. If f is nil, the
+// hook is removed.
+func (s *Server) OnRoomLeave(f RoomHandler) {
+	s.handlersMu.Lock()
+	s.roomLeaveHook = f
+	s.handlersMu.Unlock()
+}
+
+// emitRoom calls the OnRoomJoin hook if joined is true; otherwise, it calls the
+// OnRoomLeave hook
+func (s *Server) emitRoom(joined bool, r Room, c *Client) {
+	s.handlersMu.Lock()
+	hook := s.roomLeaveHook
+	if joined {
+		hook = s.roomJoinHook
+	}
+	s.handlersMu.Unlock()
+	if hook != nil {
+		hook(r, c)
+	}
+}
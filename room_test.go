@@ -0,0 +1,224 @@
+package wrapper
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// acceptClient accepts conn on server and returns the resulting *Client.
+func acceptClient(t *testing.T, server *Server, conn *mockConn) *Client {
+	t.Helper()
+	var client *Client
+	server.Once("open", func(c *Client) {
+		client = c
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	if client == nil {
+		t.Fatal("open handler did not fire")
+	}
+	return client
+}
+
+// TestRoomJoinLeave verifies that Join and Leave update room membership and
+// call the OnRoomJoin and OnRoomLeave hooks.
+func TestRoomJoinLeave(t *testing.T) {
+	server := NewServer()
+	events := make(chan string, 4)
+	server.OnRoomJoin(func(r Room, c *Client) {
+		events <- "join " + r.Name()
+	})
+	server.OnRoomLeave(func(r Room, c *Client) {
+		events <- "leave " + r.Name()
+	})
+
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer conn.Close(StatusNormalClosure, "done")
+
+	room := server.Room("lobby")
+	if err := room.Join(client); err != nil {
+		t.Fatalf("unexpected join error: %v", err)
+	}
+	// Joining twice does not call the hook again
+	if err := room.Join(client); err != nil {
+		t.Fatalf("unexpected join error: %v", err)
+	}
+	if members := room.Members(); len(members) != 1 || members[0] != client {
+		t.Fatalf("expected client to be the only member, got %v", members)
+	}
+
+	room.Leave(client)
+	room.Leave(client)
+	if members := room.Members(); len(members) != 0 {
+		t.Fatalf("expected no members, got %v", members)
+	}
+
+	for _, want := range []string{"join lobby", "leave lobby"} {
+		if got := <-events; got != want {
+			t.Fatalf("expected event %q, got %q", want, got)
+		}
+	}
+	select {
+	case got := <-events:
+		t.Fatalf("unexpected event %q", got)
+	default:
+	}
+}
+
+// TestRoomJoinDisconnectedClient verifies that a client that is not connected
+// to the server cannot join a room.
+func TestRoomJoinDisconnectedClient(t *testing.T) {
+	server := NewServer()
+	if err := server.Room("lobby").Join(NewClient(nil)); err == nil {
+		t.Fatal("expected error joining a client not connected to the server")
+	}
+
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	client.Close(StatusNormalClosure, "bye")
+	if err := server.Room("lobby").Join(client); err == nil {
+		t.Fatal("expected error joining a closed client")
+	}
+}
+
+// TestRoomLeaveOnClose verifies that a client leaves all rooms when it
+// disconnects.
+func TestRoomLeaveOnClose(t *testing.T) {
+	server := NewServer()
+	left := make(chan string, 2)
+	server.OnRoomLeave(func(r Room, c *Client) {
+		left <- r.Name()
+	})
+
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	for _, name := range []string{"a", "b"} {
+		if err := server.Room(name).Join(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client.Close(StatusNormalClosure, "bye")
+
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case name := <-left:
+			got[name] = true
+		case <-time.After(time.Second):
+			t.Fatal("OnRoomLeave hook was not called")
+		}
+	}
+	if !got["a"] || !got["b"] {
+		t.Fatalf("expected client to leave rooms a and b, got %v", got)
+	}
+	if len(server.Room("a").Members()) != 0 || len(server.rooms) != 0 {
+		t.Fatal("expected all rooms to be empty")
+	}
+}
+
+// TestRoomEmit verifies that events emitted on a room are only sent to its
+// members.
+func TestRoomEmit(t *testing.T) {
+	server := NewServer()
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	acceptClient(t, server, conn2)
+	defer conn1.Close(StatusNormalClosure, "done")
+	defer conn2.Close(StatusNormalClosure, "done")
+
+	room := server.Room("lobby")
+	if err := room.Join(client1); err != nil {
+		t.Fatal(err)
+	}
+	if errs := room.Emit(context.Background(), "chat", "hi"); len(errs) > 0 {
+		t.Fatalf("unexpected emit errors: %v", errs)
+	}
+
+	msg := conn1.waitWritten(t, time.Second)
+	if msg.EventName() != "chat" {
+		t.Fatalf("expected 'chat' event, got %+v", msg)
+	}
+	select {
+	case msg := <-conn2.writeCh:
+		t.Fatalf("unexpected message sent to non-member: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if errs := room.Emit(context.Background(), "close"); len(errs) != 1 {
+		t.Fatalf("expected error emitting reserved event, got %v", errs)
+	}
+}
+
+// TestRoomRequest verifies that a request sent to a room collects a response
+// from each member.
+func TestRoomRequest(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer conn.Close(StatusNormalClosure, "done")
+
+	room := server.Room("lobby")
+	if err := room.Join(client); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan []ClientResponse, 1)
+	go func() {
+		done <- room.Request(context.Background(), "name")
+	}()
+
+	req := conn.waitWritten(t, time.Second)
+	if req.RequestID == nil || req.EventName() != "name" {
+		t.Fatalf("expected 'name' request, got %+v", req)
+	}
+	conn.send(Message{
+		RequestID:    req.RequestID,
+		ResponseData: "alice",
+	})
+
+	select {
+	case responses := <-done:
+		if len(responses) != 1 {
+			t.Fatalf("expected 1 response, got %d", len(responses))
+		}
+		if r := responses[0]; r.Client != client || r.Error != nil ||
+			r.Data != "alice" {
+			t.Fatalf("unexpected response %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for room request")
+	}
+}
+
+// TestRoomBroadcast verifies that Broadcast skips excluded room members.
+func TestRoomBroadcast(t *testing.T) {
+	server := NewServer()
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	client2 := acceptClient(t, server, conn2)
+	defer server.Close()
+
+	room := server.Room("lobby")
+	for _, c := range []*Client{client1, client2} {
+		if err := room.Join(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), ClientKey, client1)
+	if errs := room.Broadcast(ctx, nil, "chat", "hi"); len(errs) > 0 {
+		t.Fatalf("unexpected broadcast errors: %v", errs)
+	}
+	if msg := conn2.waitWritten(t, time.Second); msg.EventName() != "chat" {
+		t.Fatalf("expected 'chat' event, got %+v", msg)
+	}
+	select {
+	case msg := <-conn1.writeCh:
+		t.Fatalf("unexpected message sent to sender: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
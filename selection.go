@@ -0,0 +1,83 @@
+package wrapper
+
+import "context"
+
+// Selection is a set of clients connected to a Server that are chosen by a
+// filter function. The filter is evaluated each time an event or request is
+// sent, so clients that connect after the Selection was created are included
+// if they match. Events emitted or requests sent are sent to the main channel
+// of each selected client unless a channel is chosen with Selection.Of.
+type Selection struct {
+	name   string
+	server *Server
+	filter func(*Client) bool
+}
+
+// Of returns a Selection of the same clients on the channel with the given
+// name
+func (s Selection) Of(name string) Selection {
+	s.name = name
+	return s
+}
+
+// Name returns the name of the channel
+func (s Selection) Name() string {
+	return s.name
+}
+
+// Clients returns the connected clients that are currently selected
+func (s Selection) Clients() []*Client {
+	clients := s.server.clientList()
+	selected := clients[:0]
+	for _, c := range clients {
+		if s.filter(c) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// Emit sends an event to all selected clients. The passed context can be used
+// to cancel writing the message to the clients. The second argument is the
+// event name that tells the remote end which event handler to call. Returns a
+// slice of ClientErrors that contains an entry if an error occurred when
+// sending the message to a specific client.
+func (s Selection) Emit(
+	ctx context.Context, arguments ...any,
+) (errs []ClientError) {
+	return emitEvent(
+		ctx, s.server.eventClients(), s.filter, s.name, arguments,
+		s.server.broadcastConcurrency(),
+	)
+}
+
+// Request sends a request to all selected clients and waits for all of them to
+// respond. See ServerChannel.Request for more information.
+func (s Selection) Request(
+	ctx context.Context, arguments ...any,
+) []ClientResponse {
+	responses, err := s.RequestWith(ctx, RequestOptions{}, arguments...)
+	if err != nil {
+		return []ClientResponse{{Client: nil, Error: err}}
+	}
+	return responses
+}
+
+// RequestWith sends a request to all selected clients and collects their
+// responses. If opts.Filter is set, only clients matching both filters
+// receive the request. See ServerChannel.RequestWith for more information.
+func (s Selection) RequestWith(
+	ctx context.Context, opts RequestOptions, arguments ...any,
+) ([]ClientResponse, error) {
+	if err := checkEmitArguments(s.name, arguments); err != nil {
+		return nil, err
+	}
+	if optsFilter := opts.Filter; optsFilter != nil {
+		opts.Filter = func(c *Client) bool {
+			return s.filter(c) && optsFilter(c)
+		}
+	} else {
+		opts.Filter = s.filter
+	}
+	return requestClients(ctx, s.server.clientList(), s.name, opts, arguments)
+}
@@ -0,0 +1,194 @@
+package wrapper
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// OverflowPolicy determines what happens when a message is sent to a client
+// whose send queue is full. See Server.SetSendQueue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is room in the queue, the context passed
+	// to the send method is done, or the connection is closed.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the message being sent and returns
+	// ErrSendQueueFull. Only events are dropped.
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued event to make room for
+	// the event being sent.
+	OverflowDropOldest
+	// OverflowDisconnect closes the client with StatusPolicyViolation and
+	// returns ErrSendQueueFull.
+	OverflowDisconnect
+)
+
+// sendQueueConfig is the size and overflow policy of a send queue. A size of
+// zero disables the send queue.
+type sendQueueConfig struct {
+	size   int
+	policy OverflowPolicy
+}
+
+// sendQueue is a bounded queue of outbound messages for a single connection.
+// A dedicated writer goroutine writes queued messages to the connection, so
+// sending a message to a slow client does not block the sender. Once a message
+// is queued, it is written with the context of the connection rather than the
+// context of its sender, so it is written even if the sender's context is
+// done by then.
+//
+// The overflow policy only applies to events. Other messages, such as the
+// responses to requests and request cancellations, are always queued, even if
+// the queue is full, because the remote end would wait for them forever if
+// they were dropped.
+type sendQueue struct {
+	ctx      context.Context // done when the connection is closed
+	size     int
+	policy   OverflowPolicy
+	overflow func()     // called when OverflowDisconnect is triggered
+	mu       sync.Mutex // protects the fields below
+	changed  *sync.Cond // signalled when items, pending, or closed change
+	items    []*Message // queued messages, oldest first
+	pending  int        // messages queued or being written
+	closed   bool       // set when the writer goroutine exits
+}
+
+// newSendQueue creates a send queue and starts its writer goroutine, which
+// writes messages to conn until ctx is done. onError is called if a write
+// fails; the writer exits afterwards. onOverflow is called when the queue
+// overflows with the OverflowDisconnect policy.
+func newSendQueue(
+	ctx context.Context,
+	conn Conn,
+	config sendQueueConfig,
+	onError func(error),
+	onOverflow func(),
+) *sendQueue {
+	q := &sendQueue{
+		ctx:      ctx,
+		size:     config.size,
+		policy:   config.policy,
+		overflow: onOverflow,
+	}
+	q.changed = sync.NewCond(&q.mu)
+	context.AfterFunc(ctx, q.signal)
+	go q.write(conn, onError)
+	return q
+}
+
+// signal wakes up all goroutines waiting for the queue to change
+func (q *sendQueue) signal() {
+	q.mu.Lock()
+	q.changed.Broadcast()
+	q.mu.Unlock()
+}
+
+// write writes queued messages to conn until the connection is closed
+func (q *sendQueue) write(conn Conn, onError func(error)) {
+	defer func() {
+		q.mu.Lock()
+		q.closed = true
+		q.changed.Broadcast()
+		q.mu.Unlock()
+	}()
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && q.ctx.Err() == nil {
+			q.changed.Wait()
+		}
+		if q.ctx.Err() != nil {
+			q.mu.Unlock()
+			return
+		}
+		msg := q.items[0]
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.changed.Broadcast() // there is room in the queue
+		q.mu.Unlock()
+
+		err := conn.WriteMessage(q.ctx, msg)
+		q.finish(1)
+		if err != nil {
+			if q.ctx.Err() == nil {
+				onError(err)
+			}
+			return
+		}
+	}
+}
+
+// finish marks n queued messages as written or discarded
+func (q *sendQueue) finish(n int) {
+	q.mu.Lock()
+	q.pending -= n
+	if q.pending == 0 {
+		q.changed.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+// droppable returns true if msg is an event, to which the overflow policy
+// applies
+func droppable(msg *Message) bool {
+	return msg.RequestID == nil && len(msg.Arguments) > 0
+}
+
+// enqueue adds msg to the queue, applying the overflow policy if the queue is
+// full. With OverflowBlock, enqueue waits until there is room in the queue or
+// ctx is done.
+func (q *sendQueue) enqueue(ctx context.Context, msg *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var stop func() bool // stops waking up enqueue when ctx is done
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	for {
+		if q.closed || q.ctx.Err() != nil {
+			return errConnectionClosed
+		}
+		if len(q.items) < q.size || !droppable(msg) {
+			q.items = append(q.items, msg)
+			q.pending++
+			q.changed.Broadcast()
+			return nil
+		}
+		switch q.policy {
+		case OverflowDropNewest:
+			return ErrSendQueueFull
+		case OverflowDropOldest:
+			i := slices.IndexFunc(q.items, droppable)
+			if i < 0 {
+				return ErrSendQueueFull // only messages that are never dropped
+			}
+			q.items = slices.Delete(q.items, i, i+1)
+			q.pending--
+			continue
+		case OverflowDisconnect:
+			go q.overflow()
+			return ErrSendQueueFull
+		}
+		// OverflowBlock
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stop == nil {
+			stop = context.AfterFunc(ctx, q.signal)
+		}
+		q.changed.Wait()
+	}
+}
+
+// flush waits until all queued messages have been written or the connection
+// is closed
+func (q *sendQueue) flush() {
+	q.mu.Lock()
+	for q.pending > 0 && !q.closed {
+		q.changed.Wait()
+	}
+	q.mu.Unlock()
+}
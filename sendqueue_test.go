@@ -0,0 +1,197 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// gatedConn is a mockConn whose writes block until the gate is opened.
+type gatedConn struct {
+	*mockConn
+	gate chan struct{}
+}
+
+func newGatedConn() *gatedConn {
+	return &gatedConn{mockConn: newMockConn(), gate: make(chan struct{})}
+}
+
+func (g *gatedConn) WriteMessage(ctx context.Context, msg *Message) error {
+	select {
+	case <-g.gate:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-g.closeCh:
+		return errors.New("connection closed")
+	}
+	return g.mockConn.WriteMessage(ctx, msg)
+}
+
+// emitAll emits each of the named events and returns the errors
+func emitAll(client *Client, names ...string) []error {
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = client.Emit(context.Background(), name)
+	}
+	return errs
+}
+
+// waitEvents returns the names of the next n events written to conn
+func waitEvents(t *testing.T, conn *mockConn, n int) []string {
+	t.Helper()
+	names := make([]string, n)
+	for i := range n {
+		names[i] = conn.waitWritten(t, time.Second).EventName()
+	}
+	return names
+}
+
+// TestSendQueueDropNewest verifies that sends do not block on a slow
+// connection and that the newest message is dropped when the queue is full.
+func TestSendQueueDropNewest(t *testing.T) {
+	conn := newGatedConn()
+	client := NewClient(nil)
+	client.SetSendQueue(1, OverflowDropNewest)
+	client.Bind(conn)
+	defer client.Close(StatusNormalClosure, "done")
+
+	// "0" is picked up by the writer, "1" waits in the queue, and "2" is
+	// dropped.
+	if err := client.Emit(context.Background(), "0"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	errs := emitAll(client, "1", "2")
+	if errs[0] != nil || !errors.Is(errs[1], ErrSendQueueFull) {
+		t.Fatalf("expected [nil ErrSendQueueFull], got %v", errs)
+	}
+
+	close(conn.gate)
+	if got := waitEvents(t, conn.mockConn, 2); got[0] != "0" || got[1] != "1" {
+		t.Fatalf("expected events [0 1], got %v", got)
+	}
+}
+
+// TestSendQueueDropOldest verifies that the oldest queued message is dropped
+// when the queue is full.
+func TestSendQueueDropOldest(t *testing.T) {
+	conn := newGatedConn()
+	client := NewClient(nil)
+	client.SetSendQueue(1, OverflowDropOldest)
+	client.Bind(conn)
+	defer client.Close(StatusNormalClosure, "done")
+
+	if err := client.Emit(context.Background(), "0"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i, err := range emitAll(client, "1", "2") {
+		if err != nil {
+			t.Fatalf("unexpected error for message %d: %v", i+1, err)
+		}
+	}
+
+	close(conn.gate)
+	if got := waitEvents(t, conn.mockConn, 2); got[0] != "0" || got[1] != "2" {
+		t.Fatalf("expected events [0 2], got %v", got)
+	}
+}
+
+// TestSendQueueDisconnect verifies that a client is closed with
+// StatusPolicyViolation when its queue overflows.
+func TestSendQueueDisconnect(t *testing.T) {
+	server := NewServer()
+	server.SetSendQueue(1, OverflowDisconnect)
+	closeStatus := make(chan StatusCode, 1)
+	server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+		closeStatus <- status
+	})
+
+	conn := newGatedConn()
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		server.Emit(context.Background(), "news")
+	}
+
+	select {
+	case status := <-closeStatus:
+		if status != StatusPolicyViolation {
+			t.Fatalf("expected StatusPolicyViolation, got %v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client was not disconnected")
+	}
+}
+
+// TestSendQueueDetachedContext verifies that a queued message is written even
+// if the context of its sender is done before it is written.
+func TestSendQueueDetachedContext(t *testing.T) {
+	conn := newGatedConn()
+	client := NewClient(nil)
+	client.SetSendQueue(2, OverflowBlock)
+	client.Bind(conn)
+	defer client.Close(StatusNormalClosure, "done")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.Emit(ctx, "0"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	close(conn.gate)
+	if got := waitEvents(t, conn.mockConn, 1); got[0] != "0" {
+		t.Fatalf("expected event 0, got %v", got)
+	}
+}
+
+// TestSendQueueKeepsResponses verifies that responses to requests are queued
+// even if the queue is full, and that DropOldest only drops events.
+func TestSendQueueKeepsResponses(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		conn := newGatedConn()
+		client := NewClient(nil)
+		client.On("echo", func(s string) (string, error) {
+			return s, nil
+		})
+		client.SetSendQueue(1, policy)
+		client.Bind(conn)
+
+		// "0" is picked up by the writer, and the response fills the queue
+		if err := client.Emit(context.Background(), "0"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		for i := range 2 {
+			reqID := i + 1
+			conn.send(Message{
+				RequestID: &reqID,
+				Arguments: []json.RawMessage{
+					[]byte(`"echo"`), []byte(`"hi"`),
+				},
+			})
+		}
+		time.Sleep(20 * time.Millisecond)
+		if err := client.Emit(context.Background(), "1"); !errors.Is(err, ErrSendQueueFull) {
+			t.Fatalf("policy %v: expected ErrSendQueueFull, got %v", policy, err)
+		}
+
+		close(conn.gate)
+		conn.waitWritten(t, time.Second) // event "0"
+		responded := map[int]bool{}
+		for range 2 {
+			resp := conn.waitWritten(t, time.Second)
+			if resp.RequestID == nil || resp.ResponseData != "hi" {
+				t.Fatalf("policy %v: expected response, got %+v", policy, resp)
+			}
+			responded[*resp.RequestID] = true
+		}
+		if !responded[1] || !responded[2] {
+			t.Fatalf("policy %v: expected responses 1 and 2, got %v", policy, responded)
+		}
+		client.Close(StatusNormalClosure, "done")
+	}
+}
@@ -1,8 +1,11 @@
 package wrapper
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	"sync"
+	"time"
 )
 
 // Server represents a server that accepts WebSocket connections, handles
@@ -15,18 +18,45 @@ type Server struct {
 	ServerChannel  // the "main" server channel with no name
 	clientsMu      sync.Mutex
 	clients        map[*Client]struct{} // set to nil when server is closed
+	shuttingDown   bool                 // set by Shutdown
+	shutdownStatus StatusCode
+	shutdownReason string
+	broadcastConc  int             // protected by clientsMu
+	sendQueueConf  sendQueueConfig // protected by clientsMu
+	binaryAttach   bool            // protected by clientsMu
+	limits         Limits          // protected by clientsMu
+	handshakeOn    bool            // protected by clientsMu
+	compatVersion  ProtocolVersion // protected by clientsMu; zero is Protocol
+	heartbeatConf  heartbeatConfig // protected by clientsMu
+	sessionConf    sessionConfig   // protected by clientsMu
 	handlersMu     sync.Mutex
 	handlers       map[handlerName]any
 	handlersOnce   map[handlerName]any
 	handlerCtxFunc HandlerContextFunc
+	roomJoinHook   RoomHandler    // protected by handlersMu
+	roomLeaveHook  RoomHandler    // protected by handlersMu
+	middleware     []Middleware   // protected by handlersMu
+	genericErrMsg  string         // protected by handlersMu
+	argPolicy      ArgumentPolicy // protected by handlersMu
+	roomsMu        sync.Mutex
+	rooms          map[string]map[*Client]struct{} // room name -> members
+	sessionsMu     sync.Mutex
+	sessions       map[string]*session   // session ID -> session
+	detached       map[*session]struct{} // sessions of disconnected clients
 }
 
 // NewServer creates a new server.
 func NewServer() *Server {
 	s := &Server{
-		clients:      make(map[*Client]struct{}),
-		handlers:     make(map[handlerName]any),
-		handlersOnce: make(map[handlerName]any),
+		clients:        make(map[*Client]struct{}),
+		shutdownStatus: StatusGoingAway,
+		shutdownReason: "server is shutting down",
+		broadcastConc:  DefaultBroadcastConcurrency,
+		handlers:       make(map[handlerName]any),
+		handlersOnce:   make(map[handlerName]any),
+		rooms:          make(map[string]map[*Client]struct{}),
+		sessions:       make(map[string]*session),
+		detached:       make(map[*session]struct{}),
 	}
 	// set reference back to client, so channel methods work properly
 	s.ServerChannel.server = s
@@ -37,17 +67,27 @@ func NewServer() *Server {
 // implement the Conn interface. If the server has been closed, Accept will
 // return an error and close conn.
 func (s *Server) Accept(conn Conn) error {
-	s.clientsMu.Lock()
+	return s.AcceptWith(conn, nil)
+}
 
-	if s.clients == nil {
+// AcceptWith is like Accept, but init is called with the new Client before the
+// connection is bound and the "open" event handlers fire. Use init to attach
+// data to the Client with Client.Set (e.g. from the HTTP request that was
+// upgraded) or to override server settings for the Client.
+func (s *Server) AcceptWith(conn Conn, init func(*Client)) error {
+	client := NewClient(nil)
+	client.server = s
+	if init != nil {
+		init(client)
+	}
+
+	// Add client to the set
+	s.clientsMu.Lock()
+	if s.clients == nil || s.shuttingDown {
 		s.clientsMu.Unlock()
 		conn.Close(StatusGoingAway, "server is closed")
 		return fmt.Errorf("server is closed and cannot accept connections")
 	}
-
-	// Add client to the set
-	client := NewClient(nil)
-	client.server = s
 	s.clients[client] = struct{}{}
 	s.clientsMu.Unlock()
 
@@ -60,25 +100,155 @@ func (s *Server) Accept(conn Conn) error {
 // Close closes the server and all connected clients. Returns the first error
 // encountered while closing clients.
 func (s *Server) Close() error {
-	var clientErr error
-
 	s.clientsMu.Lock()
 	clients := s.clients
 	s.clients = nil // stop accepting new connections
 	s.clientsMu.Unlock()
 
-	// Close all client connections
+	err := closeClients(clients, StatusGoingAway, "server is closing", false)
+	s.endSessions()
+	return err
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new
+// connections, stops calling event handlers for inbound events and requests,
+// and waits for in-flight event handlers to finish and send their responses,
+// including any messages waiting in send queues. Then, all clients are closed
+// with the status code and reason set by SetShutdownStatus.
+//
+// While shutting down, inbound messages are still read, so in-flight handlers
+// receive the responses to requests they send, and heartbeats keep working.
+// Requests received in the meantime are rejected with an error, and other
+// events are ignored.
+//
+// If ctx expires before all event handlers have finished, Shutdown closes
+// all connections immediately with Conn.CloseNow and returns the context's
+// error. Otherwise, Shutdown returns the first error encountered while closing
+// clients.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.clientsMu.Lock()
+	if s.clients == nil {
+		s.clientsMu.Unlock()
+		return nil // server already closed
+	}
+	s.shuttingDown = true // stop accepting new connections
+	status, reason := s.shutdownStatus, s.shutdownReason
+	s.clientsMu.Unlock()
+
+	// Stop calling event handlers, then wait for in-flight handlers. Once
+	// drain returns, no new handlers are started.
+	clients := s.clientList()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for _, c := range clients {
+			c.drain()
+		}
+		for _, c := range clients {
+			c.handlersWG.Wait()
+			c.flushSendQueue()
+		}
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.clientsMu.Lock()
+	clientSet := s.clients
+	s.clients = nil
+	s.clientsMu.Unlock()
+
+	closeErr := closeClients(clientSet, status, reason, err != nil)
+	s.endSessions()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// SetShutdownStatus sets the status code and reason used to close clients when
+// Shutdown completes. The default is StatusGoingAway with the reason "server
+// is shutting down".
+func (s *Server) SetShutdownStatus(status StatusCode, reason string) {
+	s.clientsMu.Lock()
+	s.shutdownStatus = status
+	s.shutdownReason = reason
+	s.clientsMu.Unlock()
+}
+
+// closeClients closes all clients in the set. If now is true, connections are
+// closed immediately without a close handshake. Returns the first error
+// encountered while closing clients.
+func closeClients(
+	clients map[*Client]struct{}, status StatusCode, reason string, now bool,
+) error {
+	var clientErr error
 	for client := range clients {
 		if err := client.close(
-			StatusGoingAway, "server is closing", false, true,
+			status, reason, false, true, now,
 		); err != nil && clientErr == nil {
 			clientErr = err
 		}
 	}
-
 	return clientErr
 }
 
+// Len returns the number of connected clients
+func (s *Server) Len() int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return len(s.clients)
+}
+
+// Clients returns an iterator over the connected clients. The iterator yields
+// the clients connected at the time iteration begins; clients that connect or
+// disconnect during iteration do not affect it.
+func (s *Server) Clients() iter.Seq[*Client] {
+	return func(yield func(*Client) bool) {
+		for _, c := range s.clientList() {
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// Select returns a Selection of the connected clients for which filter returns
+// true. Events and requests sent on the Selection are sent to the main channel
+// of the selected clients; use Selection.Of to choose another channel. The
+// filter is called without holding any Server locks, so it may call methods
+// on the Client or Server. If filter is nil, all clients are selected.
+func (s *Server) Select(filter func(*Client) bool) Selection {
+	if filter == nil {
+		filter = func(*Client) bool { return true }
+	}
+	return Selection{
+		server: s,
+		filter: filter,
+	}
+}
+
+// clientList returns a snapshot of the connected clients
+func (s *Server) clientList() []*Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// eventClients returns the clients that events sent to all clients are written
+// to: the connected clients and the disconnected clients with a session
+func (s *Server) eventClients() []*Client {
+	return s.withDetached(s.clientList(), nil)
+}
+
 // Of returns a channel for the given name
 func (s *Server) Of(name string) ServerChannel {
 	return ServerChannel{
@@ -87,6 +257,15 @@ func (s *Server) Of(name string) ServerChannel {
 	}
 }
 
+// Room returns the room with the given name. Rooms need not be created before
+// use; a room exists as long as it has at least one member.
+func (s *Server) Room(name string) Room {
+	return Room{
+		name:   name,
+		server: s,
+	}
+}
+
 // SetHandlerContext sets the handler context function. This function is called
 // before every event handler is called and is used to modify the context
 // passed to every event handler. It can be used to implement timeouts for
@@ -97,6 +276,212 @@ func (s *Server) SetHandlerContext(f HandlerContextFunc) {
 	s.handlersMu.Unlock()
 }
 
+// SetArgumentPolicy sets how event handlers are called when the number of
+// arguments received differs from the number of handler parameters. By
+// default (ArgumentsExact), an error is returned to the remote end. For
+// example, to accept calls from JavaScript clients that omit undefined
+// trailing arguments or send extra ones:
+//
+//	s.SetArgumentPolicy(wrapper.AllowMissingArguments | wrapper.AllowExtraArguments)
+//
+// Variadic handlers accept any number of trailing arguments regardless of
+// policy. The policy can be overridden per client with
+// Client.SetArgumentPolicy.
+func (s *Server) SetArgumentPolicy(policy ArgumentPolicy) {
+	s.handlersMu.Lock()
+	s.argPolicy = policy
+	s.handlersMu.Unlock()
+}
+
+// SetGenericErrorMessage sets the message sent to clients in place of the
+// message of an error returned by an event handler, so that internal details
+// are not leaked to clients. Errors that implement WrapperError are always
+// sent as-is. If message is empty (the default), error messages are sent
+// unchanged.
+func (s *Server) SetGenericErrorMessage(message string) {
+	s.handlersMu.Lock()
+	s.genericErrMsg = message
+	s.handlersMu.Unlock()
+}
+
+// SetBroadcastConcurrency sets the maximum number of clients that an event is
+// written to concurrently when it is emitted to multiple clients (i.e. by
+// ServerChannel.Emit or Room.Emit). A slow client only delays the write to
+// itself and does not stall the broadcast to other clients, as long as fewer
+// than n clients are slow. If n < 1, DefaultBroadcastConcurrency is used.
+func (s *Server) SetBroadcastConcurrency(n int) {
+	if n < 1 {
+		n = DefaultBroadcastConcurrency
+	}
+	s.clientsMu.Lock()
+	s.broadcastConc = n
+	s.clientsMu.Unlock()
+}
+
+// SetSendQueue enables a bounded outbound queue of the given size for each
+// client accepted after SetSendQueue is called. Messages sent to a client are
+// added to its queue and written to the connection by a dedicated goroutine,
+// so a client with a slow connection does not block the goroutine sending
+// the message. When a client's queue is full, policy determines what happens
+// to an event; responses to requests and other protocol messages are always
+// queued. Once a message is queued, it is written even if the context passed
+// to the send method is done. If size is zero (the default), messages are
+// written synchronously. The setting can be overridden per client with
+// Client.SetSendQueue.
+func (s *Server) SetSendQueue(size int, policy OverflowPolicy) {
+	s.clientsMu.Lock()
+	s.sendQueueConf = sendQueueConfig{size: max(size, 0), policy: policy}
+	s.clientsMu.Unlock()
+}
+
+// SetBinaryAttachments sets whether []byte arguments of events and requests
+// sent to clients are sent as binary WebSocket frames following the message
+// instead of being base64-encoded within it (see Message.Attachments). This
+// avoids the size and encoding overhead of base64 for large binary payloads,
+// but it is an extension of the ws-wrapper protocol, so it should only be
+// enabled if clients support it (i.e. clients created with NewClient). The
+// client's Conn must send and receive attachments, as the adapters in the
+// adapters subdirectory do. Attachments are never used by binary codecs,
+// which encode []byte values natively. The setting can be overridden per
+// client with Client.SetBinaryAttachments.
+//
+// Attachments received from clients are always accepted. Event handlers
+// receive them as []byte (or any) arguments.
+func (s *Server) SetBinaryAttachments(enabled bool) {
+	s.clientsMu.Lock()
+	s.binaryAttach = enabled
+	s.clientsMu.Unlock()
+}
+
+// SetLimits sets the limits on inbound messages from clients (see Limits). The
+// read limit of the connection (see Limits.MaxMessageSize) is only set for
+// clients accepted after SetLimits is called. Limits.MaxDepth is only enforced
+// for connections whose codec implements DepthCodec. The limits can be
+// overridden per client with Client.SetLimits.
+func (s *Server) SetLimits(limits Limits) {
+	s.clientsMu.Lock()
+	s.limits = limits
+	s.clientsMu.Unlock()
+}
+
+// getLimits returns the value set by SetLimits
+func (s *Server) getLimits() Limits {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.limits
+}
+
+// SetHandshake sets whether clients are sent a hello message announcing the
+// protocol version implemented by this library when they connect. The hello
+// message has the "ws-wrapper" key set to false, so ws-wrapper
+// implementations that do not support it ignore it. A remote end that
+// announces its version is always sent a hello message in reply, so the
+// handshake is completed if either end enables it. The setting can be
+// overridden per client with Client.SetHandshake.
+func (s *Server) SetHandshake(enabled bool) {
+	s.clientsMu.Lock()
+	s.handshakeOn = enabled
+	s.clientsMu.Unlock()
+}
+
+// handshake returns the value set by SetHandshake
+func (s *Server) handshake() bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.handshakeOn
+}
+
+// SetCompatibility sets the protocol version assumed for clients that do not
+// announce their version (see Client.ProtocolVersion). Features that the
+// version does not support are not used; for example, request cancellations
+// are not sent to ws-wrapper v3 clients:
+//
+//	s.SetCompatibility(wrapper.ProtocolV3)
+//
+// The default is Protocol. The setting can be overridden per client with
+// Client.SetCompatibility.
+func (s *Server) SetCompatibility(version ProtocolVersion) {
+	s.clientsMu.Lock()
+	s.compatVersion = version
+	s.clientsMu.Unlock()
+}
+
+// compatibility returns the value set by SetCompatibility
+func (s *Server) compatibility() ProtocolVersion {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if s.compatVersion == (ProtocolVersion{}) {
+		return Protocol
+	}
+	return s.compatVersion
+}
+
+// binaryAttachments returns the value set by SetBinaryAttachments
+func (s *Server) binaryAttachments() bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.binaryAttach
+}
+
+// SetHeartbeat enables a heartbeat for each client accepted after SetHeartbeat
+// is called, so that dead connections are detected even if the network does
+// not report them (i.e. a half-open TCP connection). Every interval, the
+// client's connection is pinged. If the ping is not answered within timeout,
+// the client is closed with StatusHeartbeatTimeout, and an error wrapping
+// ErrHeartbeatTimeout is emitted. If timeout is zero, interval is used. If
+// interval is zero (the default), the heartbeat is disabled.
+//
+// WebSocket pings are used if the connection implements PingConn, as the
+// adapters in the adapters subdirectory do. Otherwise, a request is sent as a
+// protocol-level ping, which every ws-wrapper implementation answers. The
+// round-trip time of the most recent ping is returned by Client.RTT. The
+// setting can be overridden per client with Client.SetHeartbeat.
+func (s *Server) SetHeartbeat(interval, timeout time.Duration) {
+	s.clientsMu.Lock()
+	s.heartbeatConf = newHeartbeatConfig(interval, timeout)
+	s.clientsMu.Unlock()
+}
+
+// heartbeatConfig returns the value set by SetHeartbeat
+func (s *Server) heartbeatConfig() heartbeatConfig {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.heartbeatConf
+}
+
+// sendQueueConfig returns the value set by SetSendQueue
+func (s *Server) sendQueueConfig() sendQueueConfig {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.sendQueueConf
+}
+
+// broadcastConcurrency returns the value set by SetBroadcastConcurrency
+func (s *Server) broadcastConcurrency() int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.broadcastConc
+}
+
+// Use adds middleware that wraps the execution of every event handler called
+// for clients of this Server. Middleware runs in the order it was added, after
+// the handler context function (see SetHandlerContext) has been applied.
+//
+// For example, the following middleware rejects requests from clients that
+// have not logged in:
+//
+//	s.Use(func(ctx context.Context, call wrapper.HandlerCall, next wrapper.NextFunc) (any, error) {
+//	    if call.Event != "login" && call.Client.Get("user") == nil {
+//	        return nil, errors.New("not logged in")
+//	    }
+//	    return next(ctx, call)
+//	})
+func (s *Server) Use(middleware ...Middleware) {
+	s.handlersMu.Lock()
+	s.middleware = append(s.middleware, middleware...)
+	s.handlersMu.Unlock()
+}
+
 // emitOpen calls the "open" and "connect" event handlers on the main channel
 func (s *Server) emitOpen(c *Client) bool {
 	return emitReserved(
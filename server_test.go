@@ -1,10 +1,14 @@
 package wrapper
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"testing"
+	"time"
 )
 
 // This example shows how to use create a ws-server-wrapper that echoes a string
@@ -73,3 +77,249 @@ func ExampleServerChannel_On_channel() {
 		return os.ReadFile(filename)
 	})
 }
+
+// TestServerBroadcast verifies that Broadcast skips the client that emitted
+// the event being handled as well as any explicitly excluded clients.
+func TestServerBroadcast(t *testing.T) {
+	server := NewServer()
+	conn1, conn2, conn3 := newMockConn(), newMockConn(), newMockConn()
+	acceptClient(t, server, conn1)
+	acceptClient(t, server, conn2)
+	client3 := acceptClient(t, server, conn3)
+	defer server.Close()
+
+	errsCh := make(chan []ClientError, 1)
+	server.On("chat", func(ctx context.Context, msg string) error {
+		errsCh <- server.Broadcast(ctx, nil, "chat", msg)
+		return nil
+	})
+
+	// conn1 sends a chat message; conn2 and conn3 receive it
+	conn1.send(Message{
+		Arguments: []json.RawMessage{[]byte(`"chat"`), []byte(`"hi"`)},
+	})
+	if errs := <-errsCh; len(errs) > 0 {
+		t.Fatalf("unexpected broadcast errors: %v", errs)
+	}
+	for _, conn := range []*mockConn{conn2, conn3} {
+		if msg := conn.waitWritten(t, time.Second); msg.EventName() != "chat" {
+			t.Fatalf("expected 'chat' event, got %+v", msg)
+		}
+	}
+	select {
+	case msg := <-conn1.writeCh:
+		t.Fatalf("unexpected message sent to sender: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Explicit exclusion without a client in the context
+	errs := server.Broadcast(context.Background(), []*Client{client3}, "news")
+	if len(errs) > 0 {
+		t.Fatalf("unexpected broadcast errors: %v", errs)
+	}
+	for _, conn := range []*mockConn{conn1, conn2} {
+		if msg := conn.waitWritten(t, time.Second); msg.EventName() != "news" {
+			t.Fatalf("expected 'news' event, got %+v", msg)
+		}
+	}
+	select {
+	case msg := <-conn3.writeCh:
+		t.Fatalf("unexpected message sent to excluded client: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestServerClientRegistry verifies that Len and Clients reflect the set of
+// connected clients.
+func TestServerClientRegistry(t *testing.T) {
+	server := NewServer()
+	if server.Len() != 0 {
+		t.Fatalf("expected no clients, got %d", server.Len())
+	}
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	client2 := acceptClient(t, server, conn2)
+	if server.Len() != 2 {
+		t.Fatalf("expected 2 clients, got %d", server.Len())
+	}
+
+	seen := map[*Client]bool{}
+	for c := range server.Clients() {
+		seen[c] = true
+		// Closing a client during iteration does not affect the iterator
+		c.Close(StatusNormalClosure, "bye")
+	}
+	if len(seen) != 2 || !seen[client1] || !seen[client2] {
+		t.Fatalf("expected both clients, got %v", seen)
+	}
+	if server.Len() != 0 {
+		t.Fatalf("expected no clients after close, got %d", server.Len())
+	}
+}
+
+// TestServerSelect verifies that events emitted on a Selection are only sent
+// to clients matching the filter.
+func TestServerSelect(t *testing.T) {
+	server := NewServer()
+	conn1, conn2 := newMockConn(), newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	acceptClient(t, server, conn2)
+	defer server.Close()
+	client1.Set("role", "admin")
+
+	admins := server.Select(func(c *Client) bool {
+		return c.Get("role") == "admin"
+	})
+	if clients := admins.Clients(); len(clients) != 1 || clients[0] != client1 {
+		t.Fatalf("expected only client1 to be selected, got %v", clients)
+	}
+
+	if errs := admins.Of("alerts").Emit(context.Background(), "fire"); len(errs) > 0 {
+		t.Fatalf("unexpected emit errors: %v", errs)
+	}
+	msg := conn1.waitWritten(t, time.Second)
+	if msg.Channel != "alerts" || msg.EventName() != "fire" {
+		t.Fatalf("expected 'fire' event on 'alerts' channel, got %+v", msg)
+	}
+	select {
+	case msg := <-conn2.writeCh:
+		t.Fatalf("unexpected message sent to unselected client: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done := make(chan []ClientResponse, 1)
+	go func() {
+		done <- admins.Request(context.Background(), "status")
+	}()
+	respond(t, conn1, "ok")
+	if responses := <-done; len(responses) != 1 ||
+		responses[0].Client != client1 || responses[0].Data != "ok" {
+		t.Fatalf("unexpected responses %v", responses)
+	}
+}
+
+// TestServerShutdown verifies that Shutdown waits for in-flight request
+// handlers to respond before closing clients with the configured status.
+func TestServerShutdown(t *testing.T) {
+	server := NewServer()
+	server.SetShutdownStatus(StatusServiceRestart, "restarting")
+	conn := newMockConn()
+	acceptClient(t, server, conn)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.On("work", func() (string, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	closeStatus := make(chan StatusCode, 1)
+	server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+		closeStatus <- status
+	})
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"work"`)},
+	})
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+
+	// New connections are rejected while shutting down
+	time.Sleep(20 * time.Millisecond)
+	if err := server.Accept(newMockConn()); err == nil {
+		t.Fatal("expected error accepting connection during shutdown")
+	}
+	select {
+	case <-closeStatus:
+		t.Fatal("client closed before handler finished")
+	default:
+	}
+	// New requests are rejected, but the connection is still read
+	reqID2 := 2
+	conn.send(Message{
+		RequestID: &reqID2,
+		Arguments: []json.RawMessage{[]byte(`"work"`)},
+	})
+	if resp := conn.waitWritten(t, time.Second); *resp.RequestID != reqID2 ||
+		resp.ResponseError == nil {
+		t.Fatalf("expected request to be rejected, got %+v", resp)
+	}
+
+	close(release)
+	resp := conn.waitWritten(t, time.Second)
+	if resp.ResponseData != "done" {
+		t.Fatalf("expected handler response, got %+v", resp)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if status := <-closeStatus; status != StatusServiceRestart {
+		t.Fatalf("expected StatusServiceRestart, got %v", status)
+	}
+	if server.Len() != 0 {
+		t.Fatalf("expected no clients after shutdown, got %d", server.Len())
+	}
+}
+
+// TestServerShutdownTimeout verifies that Shutdown closes connections
+// immediately when its context expires before handlers finish.
+func TestServerShutdownTimeout(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	acceptClient(t, server, conn)
+
+	started := make(chan struct{})
+	handlerErr := make(chan error, 1)
+	server.On("stuck", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return ctx.Err()
+	})
+	conn.send(Message{
+		Arguments: []json.RawMessage{[]byte(`"stuck"`)},
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-handlerErr:
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
+
+// TestServerAcceptWith verifies that the init function is called before the
+// "open" event handlers fire.
+func TestServerAcceptWith(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	var user any
+	server.On("open", func(c *Client) {
+		user = c.Get("user")
+	})
+	err := server.AcceptWith(newMockConn(), func(c *Client) {
+		c.Set("user", "alice")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "alice" {
+		t.Fatalf("expected user alice in open handler, got %v", user)
+	}
+
+	server.Close()
+	if err := server.AcceptWith(newMockConn(), nil); err == nil {
+		t.Fatal("expected error accepting connection on closed server")
+	}
+}
@@ -0,0 +1,503 @@
+package wrapper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultSessionBufferSize is the number of events buffered for each session
+// if no buffer is passed to Server.SetSessions
+const DefaultSessionBufferSize = 256
+
+var (
+	// ErrSessionNotFound indicates that a client tried to resume a session
+	// that does not exist or has expired
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrEventsDiscarded is returned by SessionBuffer.Replay if some of the
+	// events to be replayed were discarded, so the session cannot be resumed
+	ErrEventsDiscarded = errors.New("missed events were discarded")
+	// ErrResumeRefused indicates that the authorize function passed to
+	// Server.SetSessions refused to let a client resume a session
+	ErrResumeRefused = errors.New("session resumption refused")
+)
+
+// SessionBuffer stores the events sent to a session, so that the events a
+// client missed while it was disconnected can be replayed when it resumes the
+// session (see Server.SetSessions). Each event has a Sequence number that is
+// one greater than the previous one. Calls to a SessionBuffer are not made
+// concurrently.
+//
+// The Arguments of buffered messages are encoded with the codec passed to the
+// function creating the buffer, so a buffer that stores messages elsewhere
+// (e.g. on disk) can encode them with Codec.MarshalMessage.
+type SessionBuffer interface {
+	// Append adds an event to the buffer. The buffer may discard old events
+	// to make room for it.
+	Append(msg *Message) error
+	// Replay calls f for each buffered event with a sequence number greater
+	// than after, in order. Returns ErrEventsDiscarded if any of those events
+	// are no longer in the buffer.
+	Replay(after uint64, f func(msg *Message) error) error
+	// Close is called when the session ends
+	Close() error
+}
+
+// memoryBuffer is a SessionBuffer that keeps the most recent events in memory
+type memoryBuffer struct {
+	size      int
+	messages  []*Message
+	discarded uint64 // sequence number of the last discarded event
+}
+
+// NewMemoryBuffer returns a SessionBuffer that keeps the most recent size
+// events in memory
+func NewMemoryBuffer(size int) SessionBuffer {
+	return &memoryBuffer{size: max(size, 0)}
+}
+
+func (b *memoryBuffer) Append(msg *Message) error {
+	if b.size == 0 {
+		b.discarded = msg.Sequence
+		return nil
+	}
+	if len(b.messages) == b.size {
+		b.discarded = b.messages[0].Sequence
+		b.messages = slices.Delete(b.messages, 0, 1)
+	}
+	b.messages = append(b.messages, msg)
+	return nil
+}
+
+func (b *memoryBuffer) Replay(after uint64, f func(msg *Message) error) error {
+	if after < b.discarded {
+		return ErrEventsDiscarded
+	}
+	for _, msg := range b.messages {
+		if msg.Sequence <= after {
+			continue
+		}
+		if err := f(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memoryBuffer) Close() error {
+	b.messages = nil
+	return nil
+}
+
+// sessionConfig holds the values set by Server.SetSessions
+type sessionConfig struct {
+	ttl       time.Duration
+	newBuffer func(id string, codec Codec) SessionBuffer
+	authorize func(old, new *Client) bool
+}
+
+// session holds the state of a resumable session of a Server
+type session struct {
+	id     string
+	ttl    time.Duration
+	codec  Codec // codec used to encode buffered events
+	mu     sync.Mutex
+	seq    uint64        // sequence number of the last event; protected by mu
+	buffer SessionBuffer // protected by mu
+	// The fields below are protected by the server's sessionsMu
+	client *Client     // client bound to the session; nil once it ends
+	rooms  []string    // rooms of the disconnected client
+	expiry *time.Timer // ends the session; set while the client is disconnected
+}
+
+// missed returns the buffered events with a sequence number greater than
+// after. Returns an error if after is not the sequence number of an event
+// sent to the session or if some of the events were discarded. sess.mu must
+// be held.
+func (sess *session) missed(after uint64) ([]*Message, error) {
+	if after > sess.seq {
+		return nil, fmt.Errorf("unknown event %d", after)
+	}
+	var missed []*Message
+	err := sess.buffer.Replay(after, func(msg *Message) error {
+		missed = append(missed, msg)
+		return nil
+	})
+	return missed, err
+}
+
+// send assigns the next sequence number to an event for c, adds it to the
+// session buffer, and writes it to c. If c is disconnected, the event is only
+// buffered, so it can be replayed when the session is resumed. Returns
+// errConnectionClosed if c is no longer bound to the session.
+func (sess *session) send(
+	ctx context.Context, s *Server, c *Client, msg *Message,
+) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	s.sessionsMu.Lock()
+	bound := sess.client == c
+	s.sessionsMu.Unlock()
+	if !bound {
+		return errConnectionClosed
+	}
+	msg.Sequence = sess.seq + 1
+	msg.frame = nil // the pre-encoded frame lacks the sequence number
+	if err := sess.buffer.Append(msg); err != nil {
+		return fmt.Errorf("session buffer: %w", err)
+	}
+	sess.seq++
+	err := c.write(ctx, msg)
+	if err == errConnectionClosed {
+		return nil // event is replayed when the session is resumed
+	}
+	return err
+}
+
+// SetSessions enables resumable sessions for clients accepted afterwards.
+// Each client is assigned a session ID when it connects, and the events sent
+// to it are numbered and stored in a SessionBuffer created by newBuffer. If
+// newBuffer is nil, the most recent DefaultSessionBufferSize events are kept
+// in memory (see NewMemoryBuffer).
+//
+// When a client disconnects without being closed by the Server, its session
+// is kept for ttl. Events sent to the disconnected client, including events
+// sent to all clients or to the rooms it had joined, are buffered. If the
+// remote end reconnects within ttl and resumes the session, the events it
+// missed are replayed, and the data (see Client.Set) and rooms of the old
+// Client are restored on the new Client. Data keys that the new Client already
+// has, such as those set by an adapter's BeforeUpgrade hook, are not
+// overwritten. If ttl is zero, sessions are disabled.
+//
+// The session ID is a random secret that is only sent to the client, so by
+// default, any connection that presents it may resume the session. If
+// authorize is not nil, it is called with the old and the new Client before a
+// session is resumed, e.g. to check that both were authenticated as the same
+// user; if it returns false, the session is not resumed, the old Client is
+// left untouched, and an error wrapping ErrResumeRefused is emitted.
+//
+// Resuming a session requires a remote end that implements it, such as a
+// Client of this package; other ws-wrapper implementations ignore session
+// messages.
+func (s *Server) SetSessions(
+	ttl time.Duration,
+	newBuffer func(id string, codec Codec) SessionBuffer,
+	authorize func(old, new *Client) bool,
+) {
+	s.clientsMu.Lock()
+	s.sessionConf = sessionConfig{
+		ttl:       max(ttl, 0),
+		newBuffer: newBuffer,
+		authorize: authorize,
+	}
+	s.clientsMu.Unlock()
+}
+
+// sessionConfig returns the values set by SetSessions
+func (s *Server) sessionConfig() sessionConfig {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.sessionConf
+}
+
+// newSession creates a session for c, or returns nil if sessions are disabled
+func (s *Server) newSession(c *Client) (*session, error) {
+	conf := s.sessionConfig()
+	if conf.ttl == 0 {
+		return nil, nil
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	sess := &session{
+		id:     base64.RawURLEncoding.EncodeToString(id[:]),
+		ttl:    conf.ttl,
+		codec:  c.Codec(),
+		client: c,
+	}
+	if conf.newBuffer != nil {
+		sess.buffer = conf.newBuffer(sess.id, sess.codec)
+	} else {
+		sess.buffer = NewMemoryBuffer(DefaultSessionBufferSize)
+	}
+	s.sessionsMu.Lock()
+	s.sessions[sess.id] = sess
+	s.sessionsMu.Unlock()
+	return sess, nil
+}
+
+// detachSession keeps the session of c for its ttl after c disconnects. If
+// end is true, the session ends immediately instead.
+func (s *Server) detachSession(c *Client, end bool) {
+	c.connReqMu.Lock()
+	sess := c.session
+	c.connReqMu.Unlock()
+	if sess == nil {
+		return
+	}
+	var rooms []string
+	if !end {
+		s.roomsMu.Lock()
+		rooms = slices.Collect(maps.Keys(c.rooms))
+		s.roomsMu.Unlock()
+	}
+
+	s.sessionsMu.Lock()
+	if sess.client != c || sess.expiry != nil {
+		s.sessionsMu.Unlock()
+		return // session was resumed, has ended, or is already detached
+	} else if end {
+		s.sessionsMu.Unlock()
+		s.endSession(sess)
+		return
+	}
+	defer s.sessionsMu.Unlock()
+	sess.rooms = rooms
+	sess.expiry = time.AfterFunc(sess.ttl, func() { s.endSession(sess) })
+	s.detached[sess] = struct{}{}
+}
+
+// endSession removes the session and closes its buffer
+func (s *Server) endSession(sess *session) {
+	s.sessionsMu.Lock()
+	if sess.client == nil {
+		s.sessionsMu.Unlock()
+		return // already ended
+	}
+	delete(s.sessions, sess.id)
+	delete(s.detached, sess)
+	if sess.expiry != nil {
+		sess.expiry.Stop()
+	}
+	sess.client = nil
+	s.sessionsMu.Unlock()
+
+	sess.mu.Lock()
+	_ = sess.buffer.Close()
+	sess.mu.Unlock()
+}
+
+// endSessions ends all sessions
+func (s *Server) endSessions() {
+	s.sessionsMu.Lock()
+	sessions := slices.Collect(maps.Values(s.sessions))
+	s.sessionsMu.Unlock()
+	for _, sess := range sessions {
+		s.endSession(sess)
+	}
+}
+
+// withDetached returns clients along with the disconnected clients whose
+// sessions are selected by include, so that events sent to them are buffered.
+// If include is nil, all disconnected clients are added.
+func (s *Server) withDetached(
+	clients []*Client, include func(*session) bool,
+) []*Client {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if len(s.detached) == 0 {
+		return clients
+	}
+	for sess := range s.detached {
+		// A closing client may not have been removed from clients yet
+		if (include == nil || include(sess)) &&
+			!slices.Contains(clients, sess.client) {
+			clients = append(clients, sess.client)
+		}
+	}
+	return clients
+}
+
+// SessionID returns the ID of the client's session, or an empty string if it
+// has none. For clients accepted by a Server, it is the session assigned by
+// the Server (see Server.SetSessions); otherwise, it is the session announced
+// by the remote end, which is resumed when a new connection is bound.
+func (c *Client) SessionID() string {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	if c.session != nil {
+		return c.session.id
+	}
+	return c.sessionID
+}
+
+// bindSession assigns a session to a Client accepted by a Server and announces
+// it to the remote end. For other clients, it asks the remote end to resume
+// the session it announced on a previous connection, if any.
+func (c *Client) bindSession(ctx context.Context) error {
+	if c.server == nil {
+		c.connReqMu.Lock()
+		id, seq := c.sessionID, c.lastSeq
+		c.connReqMu.Unlock()
+		if id == "" {
+			return nil
+		}
+		return c.writeSession(ctx, id, seq)
+	}
+
+	c.connReqMu.Lock()
+	sess := c.session
+	c.connReqMu.Unlock()
+	if sess != nil {
+		return nil // session was already announced
+	}
+	sess, err := c.server.newSession(c)
+	if sess == nil || err != nil {
+		return err
+	}
+	c.connReqMu.Lock()
+	c.session = sess
+	c.connReqMu.Unlock()
+	return c.writeSession(ctx, sess.id, 0)
+}
+
+// writeSession writes a session message. A Server sends it to announce the
+// session of a client, where seq is the sequence number of the last event the
+// client has received; a client sends it to resume a session, where seq is
+// the sequence number of the last event it received. The message is ignored by
+// ws-wrapper implementations that do not support sessions.
+func (c *Client) writeSession(ctx context.Context, id string, seq uint64) error {
+	ignore := weakBool(false)
+	return c.write(ctx, &Message{
+		IgnoreIfFalse: &ignore,
+		Session:       id,
+		Sequence:      seq,
+	})
+}
+
+// handleSession handles a session message from the remote end. A Client not
+// accepted by a Server records the announced session; otherwise, the client
+// asks to resume the session.
+func (c *Client) handleSession(ctx context.Context, id string, seq uint64) error {
+	if c.server == nil {
+		c.connReqMu.Lock()
+		c.sessionID, c.lastSeq = id, seq
+		c.connReqMu.Unlock()
+		return nil
+	}
+	return c.server.resumeSession(ctx, c, id, seq)
+}
+
+// receivedEvent records the sequence number of an event received from the
+// remote end. Returns false if the event was already received, which happens
+// if it was replayed after a reconnect.
+func (c *Client) receivedEvent(seq uint64) bool {
+	c.connReqMu.Lock()
+	defer c.connReqMu.Unlock()
+	if seq <= c.lastSeq {
+		return false
+	}
+	c.lastSeq = seq
+	return true
+}
+
+// resumeSession binds the session with the specified ID to c and replays the
+// events with a sequence number greater than after. If the previous client of
+// the session is still connected, it is closed once the resumption is known
+// to be valid, so a failed resumption does not disconnect it. The data and rooms of
+// the previous client are restored on c, and the session that was assigned to
+// c when it connected ends. The session is not resumed if the authorize
+// function of the session configuration refuses it.
+func (s *Server) resumeSession(
+	ctx context.Context, c *Client, id string, after uint64,
+) error {
+	s.sessionsMu.Lock()
+	sess := s.sessions[id]
+	var old *Client
+	if sess != nil {
+		old = sess.client
+	}
+	s.sessionsMu.Unlock()
+	if sess == nil || old == nil {
+		return fmt.Errorf("resume session: %w", ErrSessionNotFound)
+	} else if old == c {
+		return nil // already bound to the session
+	} else if sess.codec.Name() != c.Codec().Name() {
+		return fmt.Errorf(
+			"resume session: session uses codec %q", sess.codec.Name(),
+		)
+	} else if authorize := s.sessionConfig().authorize; authorize != nil &&
+		!authorize(old, c) {
+		return fmt.Errorf("resume session: %w", ErrResumeRefused)
+	}
+	// Check that the missed events can be replayed before closing the
+	// previous client, so a failed resumption does not disconnect it
+	sess.mu.Lock()
+	_, err := sess.missed(after)
+	sess.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("resume session: %w", err)
+	}
+	// The previous connection may not have been detected as dead yet
+	old.close(StatusGoingAway, "session resumed", false, false, false)
+
+	sess.mu.Lock()
+	s.sessionsMu.Lock()
+	detached := sess.client == old && sess.expiry != nil
+	s.sessionsMu.Unlock()
+	if !detached {
+		sess.mu.Unlock()
+		return fmt.Errorf("resume session: %w", ErrSessionNotFound)
+	}
+	// Events may have been sent while the previous client was closed
+	missed, err := sess.missed(after)
+	if err != nil {
+		sess.mu.Unlock()
+		return fmt.Errorf("resume session: %w", err)
+	}
+
+	// Bind the session to c
+	s.sessionsMu.Lock()
+	sess.expiry.Stop()
+	sess.expiry = nil
+	delete(s.detached, sess)
+	sess.client = c
+	rooms := sess.rooms
+	sess.rooms = nil
+	s.sessionsMu.Unlock()
+	c.connReqMu.Lock()
+	prev := c.session
+	c.session = sess
+	c.connReqMu.Unlock()
+
+	// Replay missed events while holding sess.mu, so new events are written
+	// after them
+	err = c.writeSession(ctx, sess.id, after)
+	for _, msg := range missed {
+		if err != nil {
+			break
+		}
+		err = c.write(ctx, msg)
+	}
+	sess.mu.Unlock()
+	if prev != nil {
+		s.endSession(prev)
+	}
+
+	// Restore data and rooms of the previous client, keeping the data that c
+	// already has
+	old.dataMu.Lock()
+	data := maps.Clone(old.data)
+	old.dataMu.Unlock()
+	c.dataMu.Lock()
+	for key, value := range data {
+		if _, ok := c.data[key]; !ok {
+			c.data[key] = value
+		}
+	}
+	c.dataMu.Unlock()
+	for _, name := range rooms {
+		_ = s.Room(name).Join(c) // fails only if c was closed
+	}
+	if err != nil && err != errConnectionClosed {
+		return fmt.Errorf("resume session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionMessage returns a session message for the session with the given ID
+func sessionMessage(id string, seq uint64) Message {
+	ignore := weakBool(false)
+	return Message{IgnoreIfFalse: &ignore, Session: id, Sequence: seq}
+}
+
+// TestMemoryBuffer verifies that the memory buffer keeps the most recent events
+// and reports events that were discarded.
+func TestMemoryBuffer(t *testing.T) {
+	b := NewMemoryBuffer(2)
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := b.Append(&Message{Sequence: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Replay(0, func(*Message) error { return nil }); !errors.Is(err, ErrEventsDiscarded) {
+		t.Fatalf("expected ErrEventsDiscarded, got %v", err)
+	}
+	var replayed []uint64
+	err := b.Replay(1, func(msg *Message) error {
+		replayed = append(replayed, msg.Sequence)
+		return nil
+	})
+	if err != nil || len(replayed) != 2 || replayed[0] != 2 || replayed[1] != 3 {
+		t.Fatalf("expected events 2 and 3, got %v (%v)", replayed, err)
+	}
+}
+
+// TestSessionResume verifies that events sent while a client is disconnected
+// are replayed when it resumes its session, and that the data and rooms of the
+// old client are restored.
+func TestSessionResume(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer()
+	server.SetSessions(time.Minute, nil, nil)
+	closed := make(chan *Client, 1)
+	server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+		closed <- c
+	})
+	conn1 := newMockConn()
+	client1 := acceptClient(t, server, conn1)
+	defer server.Close()
+
+	announced := conn1.waitWritten(t, time.Second)
+	if announced.Session == "" || announced.Session != client1.SessionID() {
+		t.Fatalf("expected session announcement, got %+v", announced)
+	}
+	client1.Set("user", "alice")
+	if err := server.Room("lobby").Join(client1); err != nil {
+		t.Fatal(err)
+	}
+	server.Emit(ctx, "news", 1)
+	if msg := conn1.waitWritten(t, time.Second); msg.Sequence != 1 {
+		t.Fatalf("expected event 1, got %+v", msg)
+	}
+
+	// Events sent while the client is disconnected are buffered
+	conn1.Close(StatusNormalClosure, "")
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("client was not closed")
+	}
+	if errs := server.Emit(ctx, "news", 2); errs != nil {
+		t.Fatal(errs)
+	}
+	if errs := server.Room("lobby").Emit(ctx, "chat", "hi"); errs != nil {
+		t.Fatal(errs)
+	}
+
+	conn2 := newMockConn()
+	client2 := acceptClient(t, server, conn2)
+	if msg := conn2.waitWritten(t, time.Second); msg.Session == announced.Session {
+		t.Fatalf("expected new session, got %+v", msg)
+	}
+	conn2.send(sessionMessage(announced.Session, 1))
+	resumed := conn2.waitWritten(t, time.Second)
+	if resumed.Session != announced.Session || resumed.Sequence != 1 {
+		t.Fatalf("expected resumed session, got %+v", resumed)
+	}
+	for _, expected := range []string{"news", "chat"} {
+		msg := conn2.waitWritten(t, time.Second)
+		if msg.EventName() != expected {
+			t.Fatalf("expected replayed %q event, got %+v", expected, msg)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for client2.Get("user") != "alice" || len(server.Room("lobby").Members()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected data and rooms to be restored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if client2.SessionID() != announced.Session {
+		t.Fatalf("expected session %q, got %q", announced.Session, client2.SessionID())
+	}
+	server.Emit(ctx, "news", 3)
+	if msg := conn2.waitWritten(t, time.Second); msg.Sequence != 4 {
+		t.Fatalf("expected event 4, got %+v", msg)
+	}
+}
+
+// TestSessionResumeFailed verifies that a session cannot be resumed after it
+// expired or if the events the client missed were discarded.
+func TestSessionResumeFailed(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		ttl      time.Duration
+		expected error
+	}{
+		{"expired", 10 * time.Millisecond, ErrSessionNotFound},
+		{"discarded", time.Minute, ErrEventsDiscarded},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetSessions(test.ttl, func(string, Codec) SessionBuffer {
+				return NewMemoryBuffer(1)
+			}, nil)
+			closed := make(chan struct{}, 1)
+			server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+				closed <- struct{}{}
+			})
+			conn1 := newMockConn()
+			client1 := acceptClient(t, server, conn1)
+			defer server.Close()
+			id := client1.SessionID()
+
+			conn1.Close(StatusNormalClosure, "")
+			<-closed
+			errs := make(chan error, 1)
+			server.On("error", func(c *Client, err error) {
+				errs <- err
+			})
+			server.Emit(context.Background(), "news", 1)
+			server.Emit(context.Background(), "news", 2)
+			if test.ttl < time.Second {
+				time.Sleep(5 * test.ttl) // session expires
+			}
+
+			conn2 := newMockConn()
+			client2 := acceptClient(t, server, conn2)
+			conn2.send(sessionMessage(id, 0))
+			select {
+			case err := <-errs:
+				if !errors.Is(err, test.expected) {
+					t.Fatalf("expected %v, got %v", test.expected, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected error")
+			}
+			if client2.SessionID() == id {
+				t.Fatal("expected session not to be resumed")
+			}
+		})
+	}
+}
+
+// TestSessionResumeRefused verifies that a session is only resumed if the
+// authorize function allows it, and that resuming a session keeps the data the
+// new client already has.
+func TestSessionResumeRefused(t *testing.T) {
+	server := NewServer()
+	server.SetSessions(time.Minute, nil, func(old, new *Client) bool {
+		return old.Get("user") == new.Get("user")
+	})
+	errs := make(chan error, 1)
+	server.On("error", func(c *Client, err error) {
+		errs <- err
+	})
+	// accept accepts a client with the given data, as set by BeforeUpgrade
+	accept := func(conn *mockConn, data map[string]any) *Client {
+		var client *Client
+		server.Once("open", func(c *Client) {
+			client = c
+		})
+		err := server.AcceptWith(conn, func(c *Client) {
+			for key, value := range data {
+				c.Set(key, value)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.waitWritten(t, time.Second) // session announcement
+		return client
+	}
+	conn1 := newMockConn()
+	client1 := accept(conn1, map[string]any{"user": "alice", "theme": "dark"})
+	defer server.Close()
+	client1.Set("cart", 3)
+	id := client1.SessionID()
+
+	// A different user cannot take over the session, even if it is connected
+	conn2 := newMockConn()
+	client2 := accept(conn2, map[string]any{"user": "mallory"})
+	conn2.send(sessionMessage(id, 0))
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrResumeRefused) {
+			t.Fatalf("expected ErrResumeRefused, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected error")
+	}
+	if client2.SessionID() == id || client2.Get("cart") != nil {
+		t.Fatal("expected session not to be resumed")
+	}
+	if client1.SessionID() != id || client1.Emit(context.Background(), "ping") != nil {
+		t.Fatal("expected old client to remain connected")
+	}
+
+	conn3 := newMockConn()
+	client3 := accept(conn3, map[string]any{"user": "alice", "theme": "light"})
+	conn3.send(sessionMessage(id, 0))
+	deadline := time.Now().Add(time.Second)
+	for client3.Get("cart") != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected session to be resumed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if theme := client3.Get("theme"); theme != "light" {
+		t.Fatalf("expected data of the new client to be kept, got theme %v", theme)
+	}
+}
+
+// TestSessionResumeInvalid verifies that a resumption that cannot succeed
+// leaves the client bound to the session connected.
+func TestSessionResumeInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		after    uint64
+		expected string
+	}{
+		{"unknown event", 999, "unknown event 999"},
+		{"discarded", 0, ErrEventsDiscarded.Error()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer()
+			server.SetSessions(time.Minute, func(string, Codec) SessionBuffer {
+				return NewMemoryBuffer(1)
+			}, nil)
+			closed := make(chan StatusCode, 1)
+			server.On("close", func(c *Client, status StatusCode, reason string, userClosed bool) {
+				select {
+				case closed <- status:
+				default: // server.Close closes both clients
+				}
+			})
+			errs := make(chan error, 1)
+			server.On("error", func(c *Client, err error) {
+				errs <- err
+			})
+			conn1 := newMockConn()
+			client1 := acceptClient(t, server, conn1)
+			defer server.Close()
+			id := client1.SessionID()
+			server.Emit(context.Background(), "news", 1)
+			server.Emit(context.Background(), "news", 2)
+
+			conn2 := newMockConn()
+			client2 := acceptClient(t, server, conn2)
+			conn2.send(sessionMessage(id, test.after))
+			select {
+			case err := <-errs:
+				if !strings.Contains(err.Error(), test.expected) {
+					t.Fatalf("expected %q, got %v", test.expected, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected error")
+			}
+			select {
+			case status := <-closed:
+				t.Fatalf("expected live client to remain connected, closed with %d", status)
+			default:
+			}
+			if client2.SessionID() == id || client1.SessionID() != id {
+				t.Fatal("expected session not to be resumed")
+			}
+			if err := client1.Emit(context.Background(), "ping"); err != nil {
+				t.Fatalf("expected live client to remain connected, got %v", err)
+			}
+		})
+	}
+}
+
+// TestClientSession verifies that a Client resumes the session announced by
+// the remote end when it is bound to a new connection and ignores replayed
+// events it already received.
+func TestClientSession(t *testing.T) {
+	received := make(chan int, 4)
+	client := NewClient(nil)
+	client.On("news", func(n int) error {
+		received <- n
+		return nil
+	})
+	conn1 := newMockConn()
+	client.Bind(conn1)
+	conn1.send(sessionMessage("abc", 0))
+	conn1.send(Message{Arguments: []json.RawMessage{[]byte(`"news"`), []byte("1")}, Sequence: 1})
+	if n := <-received; n != 1 {
+		t.Fatalf("expected event 1, got %d", n)
+	}
+	if client.SessionID() != "abc" {
+		t.Fatalf("expected session abc, got %q", client.SessionID())
+	}
+
+	conn2 := newMockConn()
+	client.Bind(conn2)
+	defer client.Close(StatusNormalClosure, "")
+	msg := conn2.waitWritten(t, time.Second)
+	if msg.Session != "abc" || msg.Sequence != 1 {
+		t.Fatalf("expected request to resume session, got %+v", msg)
+	}
+	conn2.send(sessionMessage("abc", 1))
+	conn2.send(Message{Arguments: []json.RawMessage{[]byte(`"news"`), []byte("1")}, Sequence: 1})
+	conn2.send(Message{Arguments: []json.RawMessage{[]byte(`"news"`), []byte("2")}, Sequence: 2})
+	if n := <-received; n != 2 {
+		t.Fatalf("expected event 2, got %d", n)
+	}
+}
@@ -9,6 +9,10 @@ const (
 	StatusGoingAway       StatusCode = 1001
 	StatusProtocolError   StatusCode = 1002
 	StatusUnsupportedData StatusCode = 1003
+	// StatusNoStatusRcvd is reported for a close frame without a status code
+	// or, by adapters that cannot read close frames, for any closure by the
+	// remote end. It is never sent in a close frame.
+	StatusNoStatusRcvd StatusCode = 1005
 
 	StatusInvalidFramePayloadData StatusCode = 1007
 	StatusPolicyViolation         StatusCode = 1008
@@ -19,3 +23,8 @@ const (
 	StatusTryAgainLater           StatusCode = 1013
 	StatusBadGateway              StatusCode = 1014
 )
+
+// StatusHeartbeatTimeout is the status code with which a client is closed if
+// it does not answer a heartbeat ping in time (see Server.SetHeartbeat). It is
+// in the range that RFC 6455 reserves for applications.
+const StatusHeartbeatTimeout StatusCode = 4000
@@ -0,0 +1,255 @@
+package wrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"sync"
+)
+
+// streamBufferSize is the number of stream chunks buffered for the caller of
+// RequestStream. Once the buffer is full, the stream is cancelled rather than
+// blocking the goroutine reading messages from the connection.
+const streamBufferSize = 256
+
+// requestStream buffers the chunks and final response of a request sent by
+// ClientChannel.RequestStream until the caller receives them. The goroutine
+// reading messages from the connection never waits for the caller.
+type requestStream struct {
+	mu        sync.Mutex        // protects the fields below
+	responses []messageResponse // chunks followed by the final response
+	full      bool              // buffer overflowed; responses are ignored
+	wake      chan struct{}     // signals the caller that a response was posted
+}
+
+// post queues a response for the caller without waiting for it. It returns
+// false if the buffer is full, in which case the caller receives
+// ErrStreamBufferFull after the buffered chunks and the stream should be
+// cancelled.
+func (s *requestStream) post(resp messageResponse) bool {
+	s.mu.Lock()
+	ok := true
+	if s.full {
+		// Ignore responses after an overflow
+	} else if resp.More && len(s.responses) == streamBufferSize {
+		s.full, ok = true, false
+		s.responses = append(s.responses, messageResponse{
+			Error: ErrStreamBufferFull,
+		})
+	} else {
+		s.responses = append(s.responses, resp)
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return ok
+}
+
+// next removes and returns the oldest buffered response, if any
+func (s *requestStream) next() (messageResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.responses) == 0 {
+		return messageResponse{}, false
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, true
+}
+
+// resultStream returns an iterator over the items of result if result is a
+// stream. Handlers may return a stream as a channel (i.e. `<-chan T`) or as an
+// iter.Seq2[T, error]. Receiving from a channel stops when ctx is done.
+func resultStream(
+	ctx context.Context, result any,
+) (iter.Seq2[any, error], bool) {
+	if result == nil {
+		return nil, false
+	}
+	v := reflect.ValueOf(result)
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Chan:
+		if t.ChanDir()&reflect.RecvDir == 0 {
+			return nil, false
+		}
+		return func(yield func(any, error) bool) {
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: v},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			}
+			for {
+				chosen, item, ok := reflect.Select(cases)
+				if chosen == 1 {
+					yield(nil, context.Cause(ctx))
+					return
+				}
+				if !ok || !yield(item.Interface(), nil) {
+					return // channel closed or stream stopped
+				}
+			}
+		}, true
+	case reflect.Func:
+		// iter.Seq2[T, error] is func(yield func(T, error) bool)
+		if t.NumIn() != 1 || t.NumOut() != 0 {
+			return nil, false
+		}
+		yieldT := t.In(0)
+		if yieldT.Kind() != reflect.Func ||
+			yieldT.NumIn() != 2 || yieldT.In(1) != errorType ||
+			yieldT.NumOut() != 1 || yieldT.Out(0).Kind() != reflect.Bool {
+			return nil, false
+		}
+		return func(yield func(any, error) bool) {
+			yieldV := reflect.MakeFunc(yieldT,
+				func(args []reflect.Value) []reflect.Value {
+					var err error
+					if e := args[1].Interface(); e != nil {
+						err = e.(error)
+					}
+					more := yield(args[0].Interface(), err)
+					return []reflect.Value{reflect.ValueOf(more)}
+				},
+			)
+			v.Call([]reflect.Value{yieldV})
+		}, true
+	}
+	return nil, false
+}
+
+// errStreamUnsupported is the error response to a request whose handler
+// returned a stream if the remote end does not support streamed responses
+var errStreamUnsupported = errors.New(
+	"remote end does not support streamed responses",
+)
+
+// sendStream sends each item of stream as a chunk of the response to a
+// request, followed by the final response. If stream yields an error, the
+// error is sent as the final response and the stream stops. The stream also
+// stops when handlerCtx is done (i.e. the request was cancelled), in which
+// case no more chunks are sent and the cause is sent as the final response.
+// If the remote end did not announce CapabilityStream, no chunks are sent and
+// the request is rejected.
+func (c *Client) sendStream(
+	ctx, handlerCtx context.Context, requestID *int,
+	stream iter.Seq2[any, error],
+) error {
+	if !c.Supports(CapabilityStream) {
+		return c.sendReject(ctx, requestID, errStreamUnsupported)
+	}
+	for item, err := range stream {
+		if handlerCtx.Err() != nil {
+			break // stops the stream
+		}
+		if err != nil {
+			return c.sendReject(ctx, requestID, err)
+		}
+		err = c.writeMessage(ctx, &Message{
+			RequestID:    requestID,
+			ResponseData: item,
+			StreamChunk:  true,
+		})
+		if err == errConnectionClosed {
+			return nil // ignore message if connection is closed
+		} else if err != nil {
+			return err
+		}
+	}
+	if handlerCtx.Err() != nil {
+		return c.sendReject(ctx, requestID, context.Cause(handlerCtx))
+	}
+	return c.sendResolve(ctx, requestID, nil)
+}
+
+// requestStream sends a request with JSON-encoded arguments to the client and
+// returns an iterator over the chunks of the response. See
+// ClientChannel.RequestStream.
+func (c *Client) requestStream(
+	ctx context.Context, channel string, arguments encodedArguments,
+) iter.Seq2[any, error] {
+	return func(yield func(any, error) bool) {
+		c.connReqMu.Lock()
+		ctxClient := c.ctx
+		if c.conn == nil {
+			c.connReqMu.Unlock()
+			yield(nil, errConnectionClosed)
+			return
+		}
+		stream := &requestStream{wake: make(chan struct{}, 1)}
+		c.requestID++
+		requestID := c.requestID
+		c.requestStreams[requestID] = stream
+		c.connReqMu.Unlock()
+
+		// Send request to client
+		err := c.writeMessage(ctx, &Message{
+			Channel:     channel,
+			Arguments:   arguments.values,
+			Attachments: arguments.attachments,
+			RequestID:   &requestID,
+		})
+		if err != nil {
+			c.connReqMu.Lock()
+			delete(c.requestStreams, requestID)
+			c.connReqMu.Unlock()
+			yield(nil, fmt.Errorf("sending request: %w", err))
+			return
+		}
+
+		// Receive chunks until the final response
+		for {
+			resp, ok := stream.next()
+			if !ok {
+				select {
+				case <-stream.wake:
+				case <-ctxClient.Done():
+					yield(nil, fmt.Errorf(
+						"awaiting response: %w", context.Cause(ctxClient),
+					))
+					return
+				case <-ctx.Done():
+					cancelCause := context.Cause(ctx)
+					_ = c.sendCancel(ctxClient, &requestID, cancelCause)
+					yield(nil, fmt.Errorf("awaiting response: %w", cancelCause))
+					return
+				}
+				continue
+			}
+			if resp.Error != nil {
+				yield(nil, resp.Error)
+				return
+			} else if !resp.More {
+				return // stream complete
+			}
+			if !yield(resp.Data, nil) {
+				// Caller stopped reading; cancel the request
+				_ = c.sendCancel(ctxClient, &requestID, nil)
+				return
+			}
+		}
+	}
+}
+
+// handleStreamResponse passes a response to a request sent by
+// ClientChannel.RequestStream to the caller. ctx is the context of the
+// connection. If the caller has fallen too far behind, the request is
+// cancelled.
+func (c *Client) handleStreamResponse(
+	ctx context.Context, stream *requestStream, msg Message,
+) {
+	res, err := msg.Response()
+	ok := stream.post(messageResponse{
+		Data:  res,
+		Raw:   msg.rawResponseData,
+		Error: err,
+		More:  bool(msg.StreamChunk) && err == nil,
+	})
+	if !ok {
+		// Do not wait for the cancellation to be written
+		go c.sendCancel(ctx, msg.RequestID, ErrStreamBufferFull)
+	}
+}
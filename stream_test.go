@@ -0,0 +1,371 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStreamHandler verifies that handlers returning a channel or an
+// iter.Seq2 stream each item as a chunk followed by the final response.
+func TestStreamHandler(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+
+	server.On("count", func(n int) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := range n {
+				ch <- i
+			}
+		}()
+		return ch, nil
+	})
+	Handle(server, "words", func(
+		ctx context.Context, fail bool,
+	) (iter.Seq2[string, error], error) {
+		return func(yield func(string, error) bool) {
+			if !yield("a", nil) || !yield("b", nil) {
+				return
+			}
+			if fail {
+				yield("", errors.New("out of words"))
+			}
+		}, nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+	announce(t, conn, CapabilityStream)
+
+	tests := []struct {
+		arguments []json.RawMessage
+		chunks    []any
+		err       bool
+	}{
+		{[]json.RawMessage{[]byte(`"count"`), []byte(`3`)}, []any{0, 1, 2}, false},
+		{[]json.RawMessage{[]byte(`"words"`), []byte(`false`)}, []any{"a", "b"}, false},
+		{[]json.RawMessage{[]byte(`"words"`), []byte(`true`)}, []any{"a", "b"}, true},
+	}
+	for i, test := range tests {
+		reqID := i + 1
+		conn.send(Message{RequestID: &reqID, Arguments: test.arguments})
+		for _, chunk := range test.chunks {
+			msg := conn.waitWritten(t, time.Second)
+			if !msg.StreamChunk || msg.ResponseData != chunk {
+				t.Fatalf("expected chunk %v, got %+v", chunk, msg)
+			}
+		}
+		msg := conn.waitWritten(t, time.Second)
+		if msg.StreamChunk || msg.ResponseData != nil ||
+			(msg.ResponseError != nil) != test.err {
+			t.Fatalf("unexpected final response %+v", msg)
+		}
+	}
+}
+
+// backpressureConn is a mockConn whose writes block until the test receives
+// the message, like writes to a real connection to a slow reader
+type backpressureConn struct {
+	*mockConn
+}
+
+func (b backpressureConn) WriteMessage(ctx context.Context, msg *Message) error {
+	select {
+	case b.writeCh <- *msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// announce sends a hello message announcing caps to the client and waits for
+// the hello reply
+func announce(t *testing.T, conn *mockConn, caps ...Capability) {
+	t.Helper()
+	ignore := weakBool(false)
+	hello := Message{IgnoreIfFalse: &ignore, Version: Protocol.String()}
+	for _, capability := range caps {
+		hello.Capabilities = append(hello.Capabilities, string(capability))
+	}
+	conn.send(hello)
+	if msg := conn.waitWritten(t, time.Second); msg.Version == "" {
+		t.Fatalf("expected hello reply, got %+v", msg)
+	}
+}
+
+// TestStreamHandlerCancel verifies that a cancellation message stops a
+// streaming handler and that no chunks are sent after the final response.
+func TestStreamHandlerCancel(t *testing.T) {
+	server := NewServer()
+	conn := backpressureConn{newMockConn()}
+
+	stopped := make(chan error, 1)
+	server.On("tail", func(ctx context.Context) (<-chan string, error) {
+		ch := make(chan string)
+		go func() {
+			defer close(ch)
+			for {
+				select {
+				case ch <- "line":
+				case <-ctx.Done():
+					stopped <- context.Cause(ctx)
+					return
+				}
+			}
+		}()
+		return ch, nil
+	})
+	Handle0(server, "follow", func(
+		ctx context.Context,
+	) (iter.Seq2[string, error], error) {
+		return func(yield func(string, error) bool) {
+			for yield("line", nil) {
+			}
+			stopped <- context.Cause(ctx)
+		}, nil
+	})
+	if err := server.Accept(conn); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	announce(t, conn.mockConn, CapabilityStream)
+
+	for i, event := range []string{"tail", "follow"} {
+		reqID := i + 1
+		conn.send(Message{
+			RequestID: &reqID,
+			Arguments: []json.RawMessage{[]byte(`"` + event + `"`)},
+		})
+		if msg := conn.waitWritten(t, time.Second); !msg.StreamChunk {
+			t.Fatalf("expected chunk, got %+v", msg)
+		}
+		conn.send(Message{RequestID: &reqID, CancelReason: "enough"})
+		for {
+			msg := conn.waitWritten(t, time.Second)
+			if !msg.StreamChunk {
+				exp := map[string]any{"message": "enough"}
+				if !reflect.DeepEqual(exp, msg.ResponseError) {
+					t.Fatalf("expected cancel reason as response, got %+v", msg)
+				}
+				break
+			}
+		}
+		select {
+		case err := <-stopped:
+			if err == nil || err.Error() != "enough" {
+				t.Fatalf("expected cancel reason 'enough', got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s handler was not cancelled", event)
+		}
+		select {
+		case msg := <-conn.writeCh:
+			t.Fatalf("expected no message after final response, got %+v", msg)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// TestStreamUnsupported verifies that a streamed response is rejected without
+// sending any chunks if the remote end did not announce CapabilityStream.
+func TestStreamUnsupported(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	server.On("count", func() (<-chan int, error) {
+		ch := make(chan int, 1)
+		ch <- 1
+		close(ch)
+		return ch, nil
+	})
+	acceptClient(t, server, conn)
+	defer server.Close()
+
+	reqID := 1
+	conn.send(Message{
+		RequestID: &reqID,
+		Arguments: []json.RawMessage{[]byte(`"count"`)},
+	})
+	msg := conn.waitWritten(t, time.Second)
+	exp := map[string]any{"message": errStreamUnsupported.Error()}
+	if bool(msg.StreamChunk) || !reflect.DeepEqual(exp, msg.ResponseError) {
+		t.Fatalf("expected unsupported stream error, got %+v", msg)
+	}
+}
+
+// TestRequestStream verifies that RequestStream yields each chunk and sends a
+// cancellation when the caller stops iterating early.
+func TestRequestStream(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	results := make(chan []any, 1)
+	go func() {
+		var items []any
+		for item, err := range client.RequestStream(context.Background(), "logs") {
+			if err != nil {
+				items = append(items, err.Error())
+				break
+			}
+			items = append(items, item)
+		}
+		results <- items
+	}()
+	req := conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "a", StreamChunk: true})
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "b", StreamChunk: true})
+	conn.send(Message{RequestID: req.RequestID})
+	if items := <-results; len(items) != 2 || items[0] != "a" || items[1] != "b" {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	// Errors end the stream
+	go func() {
+		var items []any
+		for item, err := range client.RequestStream(context.Background(), "logs") {
+			if err != nil {
+				items = append(items, err.Error())
+				break
+			}
+			items = append(items, item)
+		}
+		results <- items
+	}()
+	req = conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "a", StreamChunk: true})
+	conn.send(Message{RequestID: req.RequestID, ResponseError: "disk full"})
+	if items := <-results; len(items) != 2 || items[0] != "a" || items[1] != "disk full" {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	// Stopping early sends a cancellation
+	go func() {
+		for range client.RequestStream(context.Background(), "logs") {
+			break
+		}
+		results <- nil
+	}()
+	req = conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, ResponseData: "a", StreamChunk: true})
+	<-results
+	msg := conn.waitWritten(t, time.Second)
+	if msg.RequestID == nil || *msg.RequestID != *req.RequestID || msg.CancelReason == nil {
+		t.Fatalf("expected cancellation, got %+v", msg)
+	}
+}
+
+// TestRequestStreamConcurrentRequest verifies that other requests can be sent
+// while a stream is being read, even if many chunks are waiting for the caller.
+func TestRequestStreamConcurrentRequest(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetHandshake(true)
+	server.On("count", func(n int) (<-chan int, error) {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := range n {
+				ch <- i
+			}
+		}()
+		return ch, nil
+	})
+	server.On("echo", func(s string) (string, error) {
+		return s, nil
+	})
+
+	serverConn, clientConn := Pipe()
+	accepted := make(chan *Client, 1)
+	if err := server.AcceptWith(serverConn, func(c *Client) {
+		accepted <- c
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(clientConn)
+	defer client.Close(StatusNormalClosure, "")
+	serverClient := <-accepted
+	for start := time.Now(); !serverClient.Supports(CapabilityStream); {
+		if time.Since(start) > time.Second {
+			t.Fatal("client did not announce streaming support")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var items []any
+	for item, err := range client.RequestStream(ctx, "count", 32) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) == 0 {
+			// The remaining chunks arrive while the caller is busy
+			data, err := client.Request(ctx, "echo", "hello")
+			if err != nil || data != "hello" {
+				t.Fatalf("expected echo, got %v (%v)", data, err)
+			}
+		}
+		items = append(items, item)
+	}
+	if len(items) != 32 {
+		t.Fatalf("expected 32 chunks, got %d", len(items))
+	}
+}
+
+// TestRequestStreamBufferFull verifies that a stream is cancelled if the
+// caller falls too far behind, and that the caller receives the buffered
+// chunks followed by ErrStreamBufferFull.
+func TestRequestStreamBufferFull(t *testing.T) {
+	server := NewServer()
+	conn := newMockConn()
+	client := acceptClient(t, server, conn)
+	defer server.Close()
+
+	first := make(chan struct{})
+	release := make(chan struct{})
+	type result struct {
+		count int
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var res result
+		for _, err := range client.RequestStream(context.Background(), "logs") {
+			if err != nil {
+				res.err = err
+				break
+			}
+			if res.count == 0 {
+				close(first)
+				<-release
+			}
+			res.count++
+		}
+		results <- res
+	}()
+	req := conn.waitWritten(t, time.Second)
+	conn.send(Message{RequestID: req.RequestID, ResponseData: 0, StreamChunk: true})
+	<-first
+	for i := range streamBufferSize + 1 {
+		conn.send(Message{RequestID: req.RequestID, ResponseData: i + 1, StreamChunk: true})
+	}
+	msg := conn.waitWritten(t, time.Second)
+	if msg.RequestID == nil || *msg.RequestID != *req.RequestID || msg.CancelReason == nil {
+		t.Fatalf("expected cancellation, got %+v", msg)
+	}
+	// Chunks and responses received after the overflow are ignored
+	conn.send(Message{RequestID: req.RequestID, ResponseError: "cancelled"})
+
+	close(release)
+	res := <-results
+	if res.count != streamBufferSize+1 || !errors.Is(res.err, ErrStreamBufferFull) {
+		t.Fatalf("expected %d chunks and ErrStreamBufferFull, got %d (%v)",
+			streamBufferSize+1, res.count, res.err)
+	}
+}